@@ -0,0 +1,132 @@
+package cdb64
+
+import (
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// OpenForAppend opens an existing CDB database at the given path, so that more
+// records can be added to it. Legacy databases must have been written with the
+// default hash function; versioned ones are reopened with whichever of the
+// built-in hash functions their header records, such as NewXXHash. Databases
+// written with any other hash function can't be appended to.
+//
+// The existing records are kept where they are, and new ones are written over
+// the old hash tables, which are rebuilt from scratch (including the existing
+// entries) when the Writer is closed. That makes appending a few records to a
+// large database much cheaper than rewriting it, but the cost of finalizing
+// still grows with the total number of records, and the entries for all of
+// them are held in memory until then. The file isn't a valid database again
+// until Close or Freeze returns.
+//
+// Putting a key that already exists adds a second record rather than
+// replacing the first. Get keeps returning the original value, since it's
+// found first; use GetLast for the newest value, or GetAll to see them all.
+func OpenForAppend(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cdb, err := openForAppend(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return cdb, nil
+}
+
+func openForAppend(f *os.File) (*Writer, error) {
+	db, err := New(f, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cdb := newWriter(f, db.writerOptions())
+	err = cdb.loadEntries(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// New records go where the hash tables used to start, and the old tables
+	// are dropped.
+	dataEnd := db.header[0].offset
+	err = f.Truncate(int64(dataEnd))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.Seek(int64(dataEnd), os.SEEK_SET)
+	if err != nil {
+		return nil, err
+	}
+
+	cdb.startData(int64(dataEnd))
+
+	// The checksum has to cover the existing records too.
+	if cdb.checksum != nil {
+		data := io.NewSectionReader(f, int64(db.dataStart), int64(dataEnd-db.dataStart))
+		_, err = io.Copy(cdb.checksum, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cdb, nil
+}
+
+// writerOptions returns the options a Writer needs to write a database just
+// like db.
+func (db *CDB) writerOptions() WriterOptions {
+	opts := WriterOptions{
+		Hasher:          db.hasher,
+		Versioned:       db.dataStart != headerSize,
+		TableCount:      len(db.header),
+		Checksum:        db.flags&flagChecksum != 0,
+		Codec:           db.codec,
+		SortedKeys:      db.flags&flagSortedKeys != 0,
+		RecordChecksums: db.flags&flagRecordChecksum != 0,
+		Expiry:          db.flags&flagExpiry != 0,
+		HeaderLast:      db.flags&flagHeaderLast != 0,
+		Hash128:         db.flags&flagHash128 != 0,
+	}
+
+	// Rebuild the bloom filter with the same false positive rate; an optimally
+	// sized filter with k hash functions has a rate of 2^-k.
+	if db.bloom != nil {
+		opts.BloomFalsePositiveRate = math.Pow(0.5, float64(db.bloom.k))
+	}
+
+	return opts
+}
+
+// loadEntries reads the hash tables of db back into the entry lists, in the
+// order the records were originally written.
+func (cdb *Writer) loadEntries(db *CDB) error {
+	for i, table := range db.header {
+		entries := make([]entry, 0, table.length/2)
+		for slot := uint64(0); slot < table.length; slot++ {
+			hash, offset, check, err := db.readSlot(table.offset + db.slotSize*slot)
+			if err != nil {
+				return err
+			}
+
+			if offset != 0 {
+				entries = append(entries, entry{hash: hash, offset: offset, check: check})
+			}
+		}
+
+		sort.Slice(entries, func(a, b int) bool {
+			return entries[a].offset < entries[b].offset
+		})
+
+		cdb.entries[i] = entries
+		cdb.memEntries += len(entries)
+		cdb.estimatedFooterSize += int64(len(entries)) * 2 * cdb.slotSize()
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testOpenForAppend(t *testing.T, opts WriterOptions) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, opts)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("old")))
+	}
+
+	require.NoError(t, writer.Close())
+
+	writer, err = OpenForAppend(f.Name())
+	require.NoError(t, err)
+
+	for i := 50; i < 150; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("new")))
+	}
+
+	require.NoError(t, writer.Close())
+
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Verify())
+	assert.Equal(t, 200, db.Count())
+	if opts.Checksum {
+		assert.NoError(t, db.VerifyChecksum())
+	}
+
+	for i := 0; i < 150; i++ {
+		key := []byte(strconv.Itoa(i))
+		values, err := db.GetAll(key)
+		require.NoError(t, err)
+
+		// Existing keys keep their original value, with the appended one
+		// after it.
+		switch {
+		case i < 50:
+			assert.Equal(t, [][]byte{[]byte("old")}, values)
+		case i < 100:
+			assert.Equal(t, [][]byte{[]byte("old"), []byte("new")}, values)
+		default:
+			assert.Equal(t, [][]byte{[]byte("new")}, values)
+		}
+	}
+
+	n := 0
+	iter := db.Iter()
+	for iter.Next() {
+		n++
+	}
+
+	require.NoError(t, iter.Err())
+	assert.Equal(t, 200, n)
+
+	if opts.SortedKeys {
+		n = 0
+		iter = db.ScanPrefix(nil)
+		for iter.Next() {
+			n++
+		}
+
+		require.NoError(t, iter.Err())
+		assert.Equal(t, 200, n)
+	}
+}
+
+func TestOpenForAppend(t *testing.T) {
+	testOpenForAppend(t, WriterOptions{})
+}
+
+func TestOpenForAppendChecksum(t *testing.T) {
+	testOpenForAppend(t, WriterOptions{Checksum: true})
+}
+
+func TestOpenForAppendBloom(t *testing.T) {
+	testOpenForAppend(t, WriterOptions{BloomFalsePositiveRate: 0.01})
+}
+
+func TestOpenForAppendSortedKeys(t *testing.T) {
+	testOpenForAppend(t, WriterOptions{SortedKeys: true})
+}
+
+func TestOpenForAppendHeaderLast(t *testing.T) {
+	testOpenForAppend(t, WriterOptions{HeaderLast: true, Checksum: true})
+}
+
+func TestOpenForAppendMissing(t *testing.T) {
+	_, err := OpenForAppend("./test/does-not-exist.cdb")
+	assert.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package cdb64
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// CreateAtomic is like Create, but the database is written to a temporary
+// file in the same directory as path, and only renamed over path once it's
+// finalized by Close or Freeze. That way, path only ever holds a complete
+// database: if the program crashes, or the database can't be finalized, any
+// existing file at path is left as it was.
+func CreateAtomic(path string) (*Writer, error) {
+	return CreateAtomicWithOptions(path, WriterOptions{})
+}
+
+// CreateAtomicWithOptions is like CreateAtomic, but configured by opts. If
+// opts.TempDir is set, the temporary file is created there instead of next to
+// path. If that's on a different filesystem, the finished database can't just
+// be renamed into place, so it's copied to a second temporary file next to
+// path first, and that's renamed instead.
+func CreateAtomicWithOptions(path string, opts WriterOptions) (*Writer, error) {
+	dir := opts.TempDir
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+
+	f, err := createTemp(path, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cdb, err := NewWriterWithOptions(f, opts)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	cdb.atomicPath = path
+	return cdb, nil
+}
+
+// commitAtomic moves the finished database from its temporary file to the
+// path it was created for.
+func (cdb *Writer) commitAtomic() error {
+	f := cdb.writer.(*os.File)
+	err := os.Rename(f.Name(), cdb.atomicPath)
+	if _, ok := err.(*os.LinkError); ok && cdb.options.TempDir != "" {
+		err = copyAndRename(f, cdb.atomicPath, cdb.options.Sync)
+		if err == nil {
+			os.Remove(f.Name())
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Make sure the rename itself is durable too.
+	if cdb.options.Sync {
+		if dir, err := os.Open(filepath.Dir(cdb.atomicPath)); err == nil {
+			dir.Sync()
+			dir.Close()
+		}
+	}
+
+	return nil
+}
+
+// copyAndRename copies f to a temporary file next to path, then renames that
+// over path.
+func copyAndRename(f *os.File, path string, sync bool) error {
+	tmp, err := createTemp(path, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmp, io.NewSectionReader(f, 0, 1<<63-1))
+	if err == nil && sync {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// createTemp creates a temporary file in dir, to be renamed to path. Unlike
+// most temporary files, it's readable by everyone, like a file made by
+// Create.
+func createTemp(path, dir string) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Chmod(0644)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// abortAtomic removes the temporary file of a database that couldn't be
+// finalized.
+func (cdb *Writer) abortAtomic() {
+	f := cdb.writer.(*os.File)
+	f.Close()
+	os.Remove(f.Name())
+}
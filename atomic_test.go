@@ -0,0 +1,100 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-cdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.cdb")
+	require.NoError(t, ioutil.WriteFile(path, []byte("old"), 0644))
+
+	writer, err := CreateAtomic(path)
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+
+	// Until the database is finalized, the old file is untouched.
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+
+	require.NoError(t, writer.Close())
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	value, err := db.Get([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+
+	// The temporary file is gone.
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestCreateAtomicTempDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-cdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tempDir, err := ioutil.TempDir("", "test-cdb-temp")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(dir, "test.cdb")
+	writer, err := CreateAtomicWithOptions(path, WriterOptions{TempDir: tempDir, Sync: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+	defer db.Close()
+
+	value, err := db.Get([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	files, err := ioutil.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestCopyAndRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-cdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := os.Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer f.Close()
+
+	path := filepath.Join(dir, "copy.cdb")
+	require.NoError(t, copyAndRename(f, path, true))
+
+	expected, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, data)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
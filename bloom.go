@@ -0,0 +1,101 @@
+package cdb64
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// bloomFilter is a bloom filter over key hashes. Rather than hashing keys
+// again, each of the k bit positions is derived from the key's 64-bit hash,
+// split into two 32-bit halves combined by double hashing.
+//
+// It's stored as a (k, m) tuple, where m is the number of bits, followed by
+// the bits themselves as little-endian uint64 words.
+type bloomFilter struct {
+	k    uint64
+	m    uint64
+	bits []uint64
+}
+
+// newBloomFilter sizes a bloom filter for n entries with the given false
+// positive rate.
+func newBloomFilter(n int, rate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) &^ 63
+	if m == 0 {
+		m = 64
+	}
+
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &bloomFilter{k: k, m: m, bits: make([]uint64, m/64)}
+}
+
+func (b *bloomFilter) add(hash uint64) {
+	h1, h2 := hash&0xffffffff, hash>>32
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain returns false if the hash was definitely never added.
+func (b *bloomFilter) mayContain(hash uint64) bool {
+	h1, h2 := hash&0xffffffff, hash>>32
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *bloomFilter) marshal() []byte {
+	buf := make([]byte, 16+8*len(b.bits))
+	binary.LittleEndian.PutUint64(buf[0:8], b.k)
+	binary.LittleEndian.PutUint64(buf[8:16], b.m)
+	for i, word := range b.bits {
+		binary.LittleEndian.PutUint64(buf[16+8*i:], word)
+	}
+
+	return buf
+}
+
+func readBloomFilter(r io.ReaderAt, s section) (*bloomFilter, error) {
+	if s.length < 16 {
+		return nil, fmt.Errorf("%w: bloom filter is missing", ErrCorrupt)
+	}
+
+	k, m, err := readTuple(r, s.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if k == 0 || k > m || m == 0 || m%64 != 0 || s.length != 16+m/8 {
+		return nil, fmt.Errorf("%w: bloom filter has a bad size", ErrCorrupt)
+	}
+
+	buf := make([]byte, m/8)
+	_, err = r.ReadAt(buf, int64(s.offset+16))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bloomFilter{k: k, m: m, bits: make([]uint64, m/64)}
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(buf[8*i:])
+	}
+
+	return b, nil
+}
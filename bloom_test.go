@@ -0,0 +1,94 @@
+package cdb64
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	b := newBloomFilter(10000, 0.01)
+	hasher := NewXXHash()
+	hash := func(i int) uint64 {
+		hasher.Reset()
+		hasher.Write([]byte(strconv.Itoa(i)))
+		return hasher.Sum64()
+	}
+
+	for i := 0; i < 10000; i++ {
+		b.add(hash(i))
+	}
+
+	for i := 0; i < 10000; i++ {
+		assert.True(t, b.mayContain(hash(i)))
+	}
+
+	falsePositives := 0
+	for i := 10000; i < 20000; i++ {
+		if b.mayContain(hash(i)) {
+			falsePositives++
+		}
+	}
+
+	assert.True(t, falsePositives < 200, "%d false positives", falsePositives)
+
+	// It should survive a round trip.
+	data := b.marshal()
+	loaded, err := readBloomFilter(bytes.NewReader(data), section{offset: 0, length: uint64(len(data))})
+	require.NoError(t, err)
+	assert.Equal(t, b, loaded)
+}
+
+func TestWritesReadableBloom(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{BloomFalsePositiveRate: 0.01})
+	require.NoError(t, err)
+
+	testWritesReadable(t, writer)
+
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+	require.NotNil(t, db.bloom)
+
+	for i := 100; i < 200; i++ {
+		value, err := db.Get([]byte(strconv.Itoa(i)))
+		require.NoError(t, err)
+		assert.Nil(t, value)
+	}
+}
+
+func benchmarkGetMissing(b *testing.B, opts WriterOptions) {
+	writer, err := NewWriterWithOptions(&Buffer{}, opts)
+	require.NoError(b, err)
+
+	for i := 0; i < 100000; i++ {
+		key := []byte(strconv.Itoa(i))
+		require.NoError(b, writer.Put(key, key))
+	}
+
+	db, err := writer.Freeze()
+	require.NoError(b, err)
+	defer db.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.Get([]byte("missing" + strconv.Itoa(i)))
+	}
+}
+
+func BenchmarkGetMissing(b *testing.B) {
+	benchmarkGetMissing(b, WriterOptions{})
+}
+
+func BenchmarkGetMissingBloom(b *testing.B) {
+	benchmarkGetMissing(b, WriterOptions{BloomFalsePositiveRate: 0.01})
+}
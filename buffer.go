@@ -0,0 +1,108 @@
+package cdb64
+
+import (
+	"errors"
+	"io"
+)
+
+// Buffer is an in-memory file, which grows as it's written to. It's an
+// io.WriteSeeker, so a Writer can build a database in it without touching
+// disk, and an io.ReaderAt, so that Freeze can open the database for reads
+// straight out of it, which makes it handy for tests and benchmarks. For
+// lookups that don't copy at all, open the finished database with
+// FromBytes(buf.Bytes(), ...) instead.
+//
+// The zero value is an empty buffer, ready to use. A Buffer isn't safe for
+// concurrent writes, but concurrent reads are fine once writing is done.
+type Buffer struct {
+	data []byte
+	pos  int64
+}
+
+// Write writes p at the current position, growing the buffer as needed. If
+// the position is past the end, the gap is filled with zeros, as with a file.
+func (b *Buffer) Write(p []byte) (int, error) {
+	end := b.pos + int64(len(p))
+	if end > int64(len(b.data)) {
+		if end > int64(cap(b.data)) {
+			grown := make([]byte, len(b.data), 2*end)
+			copy(grown, b.data)
+			b.data = grown
+		}
+
+		// Clear anything left behind by Truncate.
+		tail := b.data[len(b.data):end]
+		for i := range tail {
+			tail[i] = 0
+		}
+
+		b.data = b.data[:end]
+	}
+
+	copy(b.data[b.pos:], p)
+	b.pos = end
+	return len(p), nil
+}
+
+// Seek sets the position of the next Write, as with io.Seeker.
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	default:
+		return 0, errors.New("cdb64.Buffer.Seek: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("cdb64.Buffer.Seek: negative position")
+	}
+
+	b.pos = offset
+	return offset, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *Buffer) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("cdb64.Buffer.ReadAt: negative offset")
+	} else if off >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, b.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Truncate changes the size of the buffer, as with a file. It doesn't move
+// the position of the next Write.
+func (b *Buffer) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New("cdb64.Buffer.Truncate: negative size")
+	} else if size > int64(len(b.data)) {
+		pos := b.pos
+		b.pos = size
+		b.Write(nil)
+		b.pos = pos
+	}
+
+	b.data = b.data[:size]
+	return nil
+}
+
+// Size returns the size of the buffer.
+func (b *Buffer) Size() int64 {
+	return int64(len(b.data))
+}
+
+// Bytes returns the contents of the buffer. It aliases the buffer's memory,
+// so it's only valid until the next Write or Truncate.
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
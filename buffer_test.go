@@ -0,0 +1,67 @@
+package cdb64
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer(t *testing.T) {
+	var buf Buffer
+	_, err := buf.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	// Writing past the end leaves a gap of zeros.
+	_, err = buf.Seek(8, io.SeekStart)
+	require.NoError(t, err)
+	_, err = buf.Write([]byte("world"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello\x00\x00\x00world", string(buf.Bytes()))
+
+	_, err = buf.Seek(-5, io.SeekEnd)
+	require.NoError(t, err)
+	_, err = buf.Write([]byte("W"))
+	require.NoError(t, err)
+
+	p := make([]byte, 8)
+	n, err := buf.ReadAt(p, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "World", string(p[:n]))
+
+	require.NoError(t, buf.Truncate(2))
+	require.NoError(t, buf.Truncate(4))
+	assert.Equal(t, "he\x00\x00", string(buf.Bytes()))
+	assert.Equal(t, int64(4), buf.Size())
+
+	_, err = buf.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+}
+
+func TestWriteToBuffer(t *testing.T) {
+	var buf Buffer
+	writer, err := NewWriterWithOptions(&buf, WriterOptions{SortedKeys: true})
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		require.NoError(t, writer.Put(record[0], record[1]))
+	}
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+	require.NoError(t, db.Verify())
+
+	fromBytes, err := FromBytes(buf.Bytes(), nil)
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords {
+		value, err := db.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, record[1], value)
+
+		value, err = fromBytes.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, record[1], value)
+	}
+}
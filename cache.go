@@ -0,0 +1,141 @@
+package cdb64
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// CachedReaderAt is an io.ReaderAt that keeps recently read pages of an
+// underlying io.ReaderAt in memory. It's meant for backends where every
+// ReadAt is expensive, such as network storage: wrap the reader before
+// passing it to New, and repeated lookups into the same parts of the hash
+// tables will be served from memory instead of issuing a tiny read for every
+// slot. It's safe for concurrent use.
+type CachedReaderAt struct {
+	reader   io.ReaderAt
+	pageSize int64
+	maxPages int
+
+	mu    sync.Mutex
+	lru   *list.List
+	pages map[int64]*list.Element
+
+	hits   uint64
+	misses uint64
+}
+
+type cachedPage struct {
+	index int64
+	data  []byte
+}
+
+// NewCachedReaderAt returns a CachedReaderAt that reads from r in pages of
+// pageSize bytes, and keeps up to the given number of the most recently used
+// pages. If pageSize isn't positive, it defaults to 4KB, and pages is at least
+// one.
+func NewCachedReaderAt(r io.ReaderAt, pageSize, pages int) *CachedReaderAt {
+	if pageSize <= 0 {
+		pageSize = 4096
+	}
+
+	if pages <= 0 {
+		pages = 1
+	}
+
+	return &CachedReaderAt{
+		reader:   r,
+		pageSize: int64(pageSize),
+		maxPages: pages,
+		lru:      list.New(),
+		pages:    make(map[int64]*list.Element),
+	}
+}
+
+// ReadAt implements io.ReaderAt.
+func (c *CachedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		page, err := c.page(pos / c.pageSize)
+		if err != nil {
+			return n, err
+		}
+
+		start := pos % c.pageSize
+		if start >= int64(len(page)) {
+			return n, io.EOF
+		}
+
+		n += copy(p[n:], page[start:])
+		if int64(len(page)) < c.pageSize && n < len(p) {
+			// A short page is the last one in the file.
+			return n, io.EOF
+		}
+	}
+
+	return n, nil
+}
+
+// Stats returns the number of page reads served from the cache, and the number
+// that had to go to the underlying reader.
+func (c *CachedReaderAt) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+// Close closes the underlying reader, if it's an io.Closer, and drops the
+// cache.
+func (c *CachedReaderAt) Close() error {
+	c.mu.Lock()
+	c.lru.Init()
+	c.pages = make(map[int64]*list.Element)
+	c.mu.Unlock()
+
+	if closer, ok := c.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// page returns the contents of the page with the given index, which is only
+// shorter than pageSize at the end of the file.
+func (c *CachedReaderAt) page(index int64) ([]byte, error) {
+	c.mu.Lock()
+	if elem, ok := c.pages[index]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return elem.Value.(*cachedPage).data, nil
+	}
+	c.mu.Unlock()
+
+	// Read the page without holding the lock, so that slow reads don't
+	// block hits on other pages.
+	atomic.AddUint64(&c.misses, 1)
+	data := make([]byte, c.pageSize)
+	n, err := c.reader.ReadAt(data, index*c.pageSize)
+	if err != nil && !(err == io.EOF && n > 0) {
+		return nil, err
+	}
+
+	data = data[:n]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.pages[index]; ok {
+		// Another reader got here first.
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*cachedPage).data, nil
+	}
+
+	c.pages[index] = c.lru.PushFront(&cachedPage{index: index, data: data})
+	for c.lru.Len() > c.maxPages {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.pages, oldest.Value.(*cachedPage).index)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,80 @@
+package cdb64
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedReaderAt(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	c := NewCachedReaderAt(bytes.NewReader(data), 64, 4)
+
+	// Reads spanning pages, and running off the end.
+	buf := make([]byte, 100)
+	n, err := c.ReadAt(buf, 50)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, data[50:150], buf)
+
+	n, err = c.ReadAt(buf, 950)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 50, n)
+	assert.Equal(t, data[950:], buf[:n])
+
+	n, err = c.ReadAt(buf, 1000)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+
+	n, err = c.ReadAt(buf[:36], 964)
+	require.NoError(t, err)
+	assert.Equal(t, 36, n)
+
+	// The last page was cached by the reads above; the first ones have been
+	// evicted since.
+	hits, misses := c.Stats()
+	n, err = c.ReadAt(buf[:10], 990)
+	require.NoError(t, err)
+	assert.Equal(t, data[990:], buf[:10])
+
+	newHits, newMisses := c.Stats()
+	assert.Equal(t, hits+1, newHits)
+	assert.Equal(t, misses, newMisses)
+
+	_, err = c.ReadAt(buf[:10], 0)
+	require.NoError(t, err)
+	_, newMisses = c.Stats()
+	assert.Equal(t, misses+1, newMisses)
+}
+
+func TestGetCached(t *testing.T) {
+	data, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+
+	c := NewCachedReaderAt(bytes.NewReader(data), 4096, 16)
+	db, err := New(c, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		for _, record := range expectedRecords {
+			msg := "while fetching " + string(record[0])
+			value, err := db.Get(record[0])
+			require.NoError(t, err, msg)
+			assert.Equal(t, string(record[1]), string(value), msg)
+		}
+	}
+
+	// The whole file fits in the cache, so each page is only read once.
+	hits, misses := c.Stats()
+	assert.True(t, hits > misses)
+	assert.True(t, misses <= uint64(len(data)/4096+1))
+	assert.NoError(t, db.Close())
+}
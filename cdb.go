@@ -10,25 +10,93 @@ package cdb64
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"hash"
+	"hash/crc32"
 	"io"
 	"os"
+	"sort"
+	"sync"
 )
 
 const (
-	headerSize = 256 * 8 * 2
+	headerSize = defaultTables * 8 * 2
 )
 
-type Header [256]table
+// Header is the index at the head of a database with the default 256 hash
+// tables, pointing to each of them.
+type Header [defaultTables]table
+
+// HashFunc is a factory for the hash function used to place keys in the hash
+// tables. Both New and NewWriter take a HashFunc, and a nil HashFunc selects
+// the default cdb hash in either case. A database must be read with the same
+// hash function it was written with.
+//
+// Versioned databases written with one of the hash functions in this package
+// record which one in their header. New then selects it automatically if it's
+// passed a nil HashFunc, and returns an error wrapping ErrHashMismatch if it's
+// passed a different one.
 type HashFunc func() hash.Hash64
 
+// validTables reports whether the index looks like one written by Writer: the
+// hash tables follow the data, which starts at dataStart, and are laid out one
+// after the other.
+func validTables(index []table, dataStart, slotSize uint64) bool {
+	offset := index[0].offset
+	if offset < dataStart {
+		return false
+	}
+
+	for _, table := range index {
+		if table.offset != offset || table.length > (1<<63)/slotSize || offset+table.length*slotSize < offset {
+			return false
+		}
+
+		offset += table.length * slotSize
+	}
+
+	return true
+}
+
 // CDB represents an open CDB database. It can only be used for reads; to
 // create a database, use Writer.
+//
+// A CDB is safe for concurrent use by multiple goroutines.
 type CDB struct {
-	reader io.ReaderAt
-	hasher HashFunc
-	header Header
+	// mu guards the reader and everything read from its header, which
+	// Reopen replaces. Lookups hold it for reading.
+	mu sync.RWMutex
+
+	reader    io.ReaderAt
+	hasher    HashFunc
+	header    []table
+	tableBits uint
+	hashers   sync.Pool
+	dataStart uint64
+	end       uint64
+	slotSize  uint64
+	hashID    uint32
+	flags     uint32
+	sections  map[uint64]section
+	bloom     *bloomFilter
+	codec     ValueCodec
+
+	// trustHash skips comparing keys once their hashes match; see
+	// ReaderOptions.TrustHash.
+	trustHash bool
+
+	// maxValueSize is ReaderOptions.MaxValueSize.
+	maxValueSize int64
+
+	// maxProbes is ReaderOptions.MaxProbes.
+	maxProbes int
+
+	// shared marks a clone that reads from another CDB's reader, which it
+	// mustn't close.
+	shared bool
 }
 
 type table struct {
@@ -46,87 +114,967 @@ func Open(path string) (*CDB, error) {
 	return New(f, nil)
 }
 
+// IsCDB reports whether the file at path looks like a cdb64 database, without
+// opening it for lookups. See IsCDBReader.
+func IsCDB(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+	return IsCDBReader(f)
+}
+
+// IsCDBReader reports whether r looks like a cdb64 database: that its
+// preamble, if it has one, is one this package can read, and that its hash
+// tables follow the data one after another, within the bounds of r if it
+// knows its size. It reads the header, and the list of trailer sections, but
+// not their contents, such as the bloom filter, so it's much cheaper than
+// opening the database; nor does it check the records themselves, which is
+// what Verify is for. A database whose values are encoded with an unregistered
+// codec still counts, even though it can't be opened until the codec is
+// registered. A header that's malformed or truncated makes it return false,
+// while other errors, such as failing to read from r at all, are returned.
+func IsCDBReader(r io.ReaderAt) (bool, error) {
+	if r == nil {
+		return false, ErrNilReader
+	}
+
+	err := (&CDB{reader: r}).parseHeader(false)
+	if err == nil {
+		return true, nil
+	}
+
+	for _, target := range []error{ErrBadMagic, ErrUnsupportedVersion, ErrCorruptHeader, ErrCorrupt, io.EOF, io.ErrUnexpectedEOF} {
+		if errors.Is(err, target) {
+			return false, nil
+		}
+	}
+
+	return false, err
+}
+
+// FromBytes opens a CDB database held entirely in memory, such as one read
+// from a file or built with a Writer over an in-memory buffer. The data must
+// not be modified while the database is in use. Like OpenMmap, lookups read
+// straight out of data, without copying tuples or keys.
+//
+// As with New, if hasher is nil it will default to the CDB hash function.
+func FromBytes(data []byte, hasher HashFunc) (*CDB, error) {
+	return New(&bytesReader{data: data}, hasher)
+}
+
 // New opens a new CDB instance for the given io.ReaderAt. It can only be used
 // for reads; to create a database, use Writer.
 //
-// If hasher is nil, it will default to the CDB hash function. If a database
-// was created with a particular hash function, that same hash function must be
-// passed to New, or the database will return incorrect results.
+// If hasher is nil, it will default to the CDB hash function, or to the hash
+// function recorded in the header, if there is one. If a database was created
+// with a particular hash function, that same hash function must be passed to
+// New, or the database will return incorrect results; if the header records a
+// different one, New returns an error wrapping ErrHashMismatch.
+//
+// If reader knows its own size, because it has a Size or Stat method like
+// *bytes.Reader and *os.File do, the header is checked against it, and New
+// returns an error wrapping ErrCorruptHeader if it points past the end.
 func New(reader io.ReaderAt, hasher HashFunc) (*CDB, error) {
-	if hasher == nil {
-		hasher = newCDBHash
+	if reader == nil {
+		return nil, ErrNilReader
 	}
 
-	cdb := &CDB{reader: reader, hasher: hasher}
+	cdb := &CDB{reader: reader}
 	err := cdb.readHeader()
 	if err != nil {
 		return nil, err
 	}
 
+	cdb.hasher, err = cdb.selectHasher(hasher)
+	if err != nil {
+		return nil, err
+	}
+
 	return cdb, nil
 }
 
+// ReaderOptions configures a CDB opened with NewWithOptions.
+type ReaderOptions struct {
+	// Hasher is the hash function the database was written with. If nil, it
+	// defaults to the CDB hash function.
+	Hasher HashFunc
+
+	// TrustHash makes lookups return the first record whose 64-bit hash and
+	// key length match the key, without reading the stored key to compare
+	// it. That saves a read and a comparison on every lookup, but if two
+	// keys of the same length ever hash the same, a lookup for one returns
+	// the other's value, and a lookup for a missing key can return a value
+	// that isn't its own. With a good 64-bit hash, such as xxHash, that's
+	// vanishingly unlikely, but it's still only safe for trusted databases
+	// whose keys are known not to collide, for example ones that have passed
+	// Verify; the default CDB hash collides much more easily. It's off by
+	// default.
+	TrustHash bool
+
+	// MaxValueSize, if positive, is the largest value lookups and iterators
+	// will read, in bytes, as stored in the file. Records that claim to be
+	// larger return ErrValueTooLarge before anything is allocated for them,
+	// so a corrupt or hostile file can't make a read use unbounded memory.
+	MaxValueSize int64
+
+	// MaxProbes, if positive, is the most hash table slots a lookup will
+	// read. A lookup normally reads slots until it finds the key or an empty
+	// slot, which in a nearly full table, or one filled with colliding keys,
+	// can mean reading the whole table. With MaxProbes, a lookup that reads
+	// that many slots without reaching the end of its probe sequence gives up
+	// and returns an error wrapping ErrProbeLimitExceeded, so that the
+	// latency of a lookup is bounded, at the cost of failing to find some
+	// keys in degenerate tables. In a healthy table, lookups rarely read
+	// more than a handful of slots.
+	MaxProbes int
+}
+
+// ErrHashMismatch is returned (wrapped with details) by New when the hash
+// function it's given isn't the one the database records it was written with.
+var ErrHashMismatch = errors.New("database was written with a different hash function")
+
+// ErrProbeLimitExceeded is returned (wrapped with details) by lookups that
+// give up after reading ReaderOptions.MaxProbes slots.
+var ErrProbeLimitExceeded = errors.New("lookup exceeded the maximum number of probes")
+
+// ErrNilReader is returned by New and the functions built on it when they're
+// given a nil reader.
+var ErrNilReader = errors.New("cdb64: nil reader")
+
+// ErrValueTooLarge is returned when a record's value is larger than
+// ReaderOptions.MaxValueSize.
+var ErrValueTooLarge = errors.New("value is larger than the maximum value size")
+
+// NewWithOptions is like New, but configured by opts.
+func NewWithOptions(reader io.ReaderAt, opts ReaderOptions) (*CDB, error) {
+	cdb, err := New(reader, opts.Hasher)
+	if err != nil {
+		return nil, err
+	}
+
+	cdb.trustHash = opts.TrustHash
+	cdb.maxValueSize = opts.MaxValueSize
+	cdb.maxProbes = opts.MaxProbes
+	return cdb, nil
+}
+
+// NewWithSize is like New, but for readers that don't know their own size,
+// such as ones doing ranged reads from object storage. The header is checked
+// against size, just as New checks it against the size of a file, and reads
+// past it fail with io.EOF.
+func NewWithSize(reader io.ReaderAt, size int64, hasher HashFunc) (*CDB, error) {
+	if reader == nil {
+		return nil, ErrNilReader
+	}
+
+	return New(&sizedReader{ReaderAt: reader, size: size}, hasher)
+}
+
+// NewAt is like New, but for a database embedded in a larger file, such as an
+// archive, starting at offset base of reader. All offsets in the database are
+// relative to its start, so they're read from base onwards. If reader knows
+// its own size, the database is taken to run to the end of it, and checked
+// against that just as New does; to check it against its own size instead, or
+// for readers that don't know theirs, wrap reader with io.NewSectionReader
+// and pass it to NewWithSize.
+func NewAt(reader io.ReaderAt, base int64, hasher HashFunc) (*CDB, error) {
+	if reader == nil {
+		return nil, ErrNilReader
+	} else if base < 0 {
+		return nil, fmt.Errorf("negative base offset %d", base)
+	}
+
+	var r io.ReaderAt = &offsetReader{ReaderAt: reader, base: base}
+	if size, ok := readerSize(reader); ok {
+		if base > size {
+			return nil, fmt.Errorf("%w: base offset %d is past the end of the file", ErrCorruptHeader, base)
+		}
+
+		r = &sizedReader{ReaderAt: r, size: size - base}
+	}
+
+	return New(r, hasher)
+}
+
 // Get returns the value for a given key, or nil if it can't be found.
 func (cdb *CDB) Get(key []byte) ([]byte, error) {
-	hasher := cdb.hasher()
-	hasher.Reset()
-	hasher.Write(key)
-	hash := hasher.Sum64()
+	var value []byte
+	err := cdb.probe(key, func(offset uint64) (bool, error) {
+		v, err := cdb.getValueAt(offset, key, false)
+		value = v
+		return v != nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	table := cdb.header[hash&0xff]
-	if table.length == 0 {
-		return nil, nil
+	return value, nil
+}
+
+// GetWithHash is like Get, but uses hash to find the key instead of hashing
+// it, for callers that already have it to hand. The hash must be exactly what
+// the database's hash function gives for key, or the key won't be found. The
+// stored key is still compared against key, as usual.
+func (cdb *CDB) GetWithHash(key []byte, hash uint64) ([]byte, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	var value []byte
+	err := cdb.probeHash(context.Background(), hash, cdb.keyCheck(key), func(offset uint64) (bool, error) {
+		v, err := cdb.getValueAt(offset, key, false)
+		value = v
+		return v != nil, err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Probe the given hash table, starting at the given slot.
-	startingSlot := (hash >> 8) % table.length
-	slot := startingSlot
+	return value, nil
+}
+
+// GetOK is like Get, but also returns whether the key was found at all, so
+// that a missing key can't be mistaken for an empty value. (Get already
+// returns a non-nil, empty slice for an empty value, but the explicit bool is
+// harder to get wrong.)
+func (cdb *CDB) GetOK(key []byte) ([]byte, bool, error) {
+	value, err := cdb.Get(key)
+	if err != nil || value == nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+// GetContext is like Get, but gives up as soon as ctx is done, returning
+// ctx.Err(). The context is checked before every read from the underlying
+// reader, which bounds how long a lookup can take when that reader is slow,
+// such as one backed by network storage.
+func (cdb *CDB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	var value []byte
+	err := cdb.probeContext(ctx, key, func(offset uint64) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+
+		v, err := cdb.getValueAt(offset, key, false)
+		value = v
+		return v != nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// GetUnsafe is like Get, but for databases opened with OpenMmap or FromBytes,
+// it returns a slice of the mapped file or of the data itself rather than a
+// copy, so lookups don't allocate at all. For other databases, and for
+// databases written with a ValueCodec, it's the same as Get.
+//
+// The returned slice is only valid until the database is closed; using it
+// afterwards will crash the program. It must never be modified, since the
+// mapping is read-only, and writing to it will also crash the program.
+func (cdb *CDB) GetUnsafe(key []byte) ([]byte, error) {
+	var value []byte
+	err := cdb.probe(key, func(offset uint64) (bool, error) {
+		v, err := cdb.getValueAt(offset, key, true)
+		value = v
+		return v != nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Has returns whether the given key exists in the database. Unlike Get, it
+// only reads the stored keys it has to compare against, never the values.
+func (cdb *CDB) Has(key []byte) (bool, error) {
+	found := false
+	err := cdb.probe(key, func(offset uint64) (bool, error) {
+		_, ok, err := cdb.matchKey(offset, key, nil)
+		found = ok
+		return ok, err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// SizeOf returns the length of the value for a given key, and whether the key
+// was found at all, without reading the value, so that callers can decide
+// whether a large value is worth fetching.
+//
+// For databases written with a ValueCodec, the length of the decoded value
+// isn't stored anywhere, so the value is read and decoded after all.
+func (cdb *CDB) SizeOf(key []byte) (int64, bool, error) {
+	if cdb.codec != nil {
+		value, err := cdb.Get(key)
+		return int64(len(value)), value != nil, err
+	}
+
+	var size int64
+	found := false
+	err := cdb.probe(key, func(offset uint64) (bool, error) {
+		valueLength, ok, err := cdb.matchKey(offset, key, nil)
+		found = ok
+		size = int64(valueLength)
+		return ok, err
+	})
+	if err != nil || !found {
+		return 0, false, err
+	}
+
+	// Record checksums and expiry times are stored as part of the value.
+	if cdb.flags&flagRecordChecksum != 0 {
+		size -= crc32.Size
+	}
+
+	if cdb.flags&flagExpiry != 0 {
+		size -= 8
+	}
 
-	for {
-		slotOffset := table.offset + (16 * slot)
-		slotHash, offset, err := readTuple(cdb.reader, slotOffset)
+	if size < 0 {
+		return 0, true, fmt.Errorf("%w: key %q: value is too short", ErrRecordCorrupt, key)
+	}
+
+	return size, true, nil
+}
+
+// GetAll returns every value stored under the given key, in the order they
+// were written, or nil if the key can't be found.
+func (cdb *CDB) GetAll(key []byte) ([][]byte, error) {
+	var values [][]byte
+	err := cdb.probe(key, func(offset uint64) (bool, error) {
+		v, err := cdb.getValueAt(offset, key, false)
+		if v != nil {
+			values = append(values, v)
+		}
+
+		// Keep probing, since later slots may hold more records for the key.
+		return false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// MultiGet returns the values for all of the given keys at once, in the same
+// order as the keys, with nil for any that can't be found. It's the same as
+// calling Get for each key, but the lookups are reordered so that the hash
+// tables are read from the start of the file to the end, rather than at
+// random, which helps throughput for large batches.
+func (cdb *CDB) MultiGet(keys [][]byte) ([][]byte, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	type lookup struct {
+		index int
+		hash  uint64
+		slot  uint64
+	}
+
+	lookups := make([]lookup, len(keys))
+	for i, key := range keys {
+		hash := cdb.hashKey(key)
+		lookups[i] = lookup{index: i, hash: hash}
+		if length := cdb.header[tableFor(hash, cdb.tableBits)].length; length > 0 {
+			lookups[i].slot = firstSlot(hash, cdb.tableBits, length)
+		}
+	}
+
+	sort.Slice(lookups, func(a, b int) bool {
+		ta, tb := tableFor(lookups[a].hash, cdb.tableBits), tableFor(lookups[b].hash, cdb.tableBits)
+		if ta != tb {
+			return ta < tb
+		}
+
+		return lookups[a].slot < lookups[b].slot
+	})
+
+	values := make([][]byte, len(keys))
+	for _, l := range lookups {
+		key := keys[l.index]
+		err := cdb.probeHash(context.Background(), l.hash, cdb.keyCheck(key), func(offset uint64) (bool, error) {
+			v, err := cdb.getValueAt(offset, key, false)
+			values[l.index] = v
+			return v != nil, err
+		})
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	return values, nil
+}
+
+// MissingValue is the offset GetPacked returns for keys that can't be found.
+const MissingValue = -1
+
+// GetPacked is like MultiGet, but packs all the values into a single slice,
+// data, so that a batch of lookups makes one allocation for the values rather
+// than one per key, and the values sit next to each other in memory. offsets
+// has an entry for each key, plus a final one that's always len(data). For a
+// key that can't be found, the entry is MissingValue; otherwise it's where
+// the key's value starts in data, and the value runs up to the next entry that
+// isn't MissingValue.
+func (cdb *CDB) GetPacked(keys [][]byte) ([]byte, []int, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	// Find every record first, to size data.
+	type found struct {
+		ok     bool
+		offset uint64
+		length uint64
+	}
+
+	records := make([]found, len(keys))
+	var total uint64
+	for i, key := range keys {
+		err := cdb.probeLocked(context.Background(), key, func(offset uint64) (bool, error) {
+			valueLength, ok, err := cdb.matchKey(offset, key, nil)
+			if err != nil || !ok {
+				return false, err
+			}
+
+			_, err = cdb.recordEnd(offset, uint64(len(key)), valueLength)
+			if err == nil {
+				err = cdb.checkValueSize(offset, valueLength)
+			}
 
-		// An empty slot means the key doesn't exist.
-		if slotHash == 0 {
-			break
-		} else if slotHash == hash {
-			value, err := cdb.getValueAt(offset, key)
 			if err != nil {
-				return nil, err
-			} else if value != nil {
-				return value, nil
+				return false, err
 			}
+
+			records[i] = found{ok: true, offset: offset + 16 + uint64(len(key)), length: valueLength}
+			total += valueLength
+			return true, nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Stored values are read straight into data, unless they have to be
+	// decoded, in which case total is only a guess at the size.
+	transform := cdb.transformsValues()
+	data := make([]byte, 0, total)
+	offsets := make([]int, len(keys)+1)
+	var scratch []byte
+	for i, record := range records {
+		if !record.ok {
+			offsets[i] = MissingValue
+			continue
 		}
 
-		slot = (slot + 1) % table.length
-		if slot == startingSlot {
-			break
+		offsets[i] = len(data)
+		if !transform {
+			start := len(data)
+			data = data[:start+int(record.length)]
+			_, err := cdb.reader.ReadAt(data[start:], int64(record.offset))
+			if err != nil {
+				return nil, nil, err
+			}
+
+			continue
+		}
+
+		stored, err := readAt(cdb.reader, record.offset, record.length, scratch)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		scratch = stored
+		value, _, err := cdb.decodeRecord(keys[i], stored)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		data = append(data, value...)
+	}
+
+	offsets[len(keys)] = len(data)
+	return data, offsets, nil
+}
+
+// GetFirst returns the value of the first record written for a given key, or
+// nil if it can't be found. Get returns whichever matching record it comes
+// across first while probing the hash table; for databases written by Writer
+// that's also the first one written, but GetFirst guarantees it by checking
+// every matching record and picking the one earliest in the file.
+func (cdb *CDB) GetFirst(key []byte) ([]byte, error) {
+	return cdb.getByOffset(key, false)
+}
+
+// GetLast returns the value of the last record written for a given key, or
+// nil if it can't be found. That allows later records to override earlier
+// ones, for example when records are appended with OpenForAppend. Like
+// GetFirst, it checks every matching record, so it's slower than Get.
+func (cdb *CDB) GetLast(key []byte) ([]byte, error) {
+	return cdb.getByOffset(key, true)
+}
+
+// getByOffset returns the value of the matching record with the lowest
+// offset, or the highest if last is true.
+func (cdb *CDB) getByOffset(key []byte, last bool) ([]byte, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	var best uint64
+	found := false
+	err := cdb.probeLocked(context.Background(), key, func(offset uint64) (bool, error) {
+		_, ok, err := cdb.matchKey(offset, key, nil)
+		if ok && (!found || (offset > best) == last) {
+			best = offset
+			found = true
 		}
+
+		return false, err
+	})
+	if err != nil || !found {
+		return nil, err
 	}
 
-	return nil, nil
+	return cdb.getValueAt(best, key, false)
+}
+
+// GetReader returns a reader for the value of a given key, along with the
+// length of the value, without reading the value itself into memory. If the
+// key can't be found, the returned reader is nil.
+//
+// For databases written with a ValueCodec, record checksums or expiry times,
+// the value has to be decoded or checked as a whole, so it is read into
+// memory after all.
+func (cdb *CDB) GetReader(key []byte) (io.Reader, int64, error) {
+	if cdb.transformsValues() {
+		value, err := cdb.Get(key)
+		if err != nil || value == nil {
+			return nil, 0, err
+		}
+
+		return bytes.NewReader(value), int64(len(value)), nil
+	}
+
+	var section *io.SectionReader
+	err := cdb.probe(key, func(offset uint64) (bool, error) {
+		valueLength, ok, err := cdb.matchKey(offset, key, nil)
+		if ok {
+			valueOffset := int64(offset+16) + int64(len(key))
+			section = io.NewSectionReader(cdb.reader, valueOffset, int64(valueLength))
+		}
+
+		return ok, err
+	})
+	if err != nil || section == nil {
+		return nil, 0, err
+	}
+
+	return section, section.Size(), nil
+}
+
+// GetInto looks up the value for a given key and copies it into dst, so that
+// a single buffer can be reused across many lookups. It returns the length of
+// the value and whether the key was found. If the value is longer than
+// cap(dst), nothing is copied; the caller should grow dst to at least the
+// returned length and try again.
+func (cdb *CDB) GetInto(key, dst []byte) (int, bool, error) {
+	if cdb.transformsValues() {
+		value, err := cdb.Get(key)
+		if err != nil || value == nil {
+			return 0, false, err
+		} else if len(value) <= cap(dst) {
+			copy(dst[:len(value)], value)
+		}
+
+		return len(value), true, nil
+	}
+
+	var n int
+	found := false
+	err := cdb.probe(key, func(offset uint64) (bool, error) {
+		valueLength, ok, err := cdb.matchKey(offset, key, dst)
+		if err != nil || !ok {
+			return false, err
+		}
+
+		_, err = cdb.recordEnd(offset, uint64(len(key)), valueLength)
+		if err == nil {
+			err = cdb.checkValueSize(offset, valueLength)
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		found = true
+		n = int(valueLength)
+		if n > cap(dst) {
+			return true, nil
+		}
+
+		_, err = cdb.reader.ReadAt(dst[:n], int64(offset+16)+int64(len(key)))
+		return true, err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	return n, found, nil
+}
+
+// GetString is like Get, but takes the key and returns the value as strings.
+// The returned bool reports whether the key was found at all, so that a
+// missing key can be told apart from an empty value.
+func (cdb *CDB) GetString(key string) (string, bool, error) {
+	value, err := cdb.Get(stringBytes(key))
+	if err != nil || value == nil {
+		return "", false, err
+	}
+
+	return string(value), true, nil
+}
+
+// GetUint64 returns the value for a given key, decoded as an 8-byte
+// little-endian integer, as written by Writer.PutUint64. The returned bool
+// reports whether the key was found. If the stored value isn't 8 bytes long,
+// an error is returned.
+func (cdb *CDB) GetUint64(key []byte) (uint64, bool, error) {
+	var buf [8]byte
+	n, found, err := cdb.GetInto(key, buf[:])
+	if err != nil || !found {
+		return 0, false, err
+	} else if n != 8 {
+		return 0, true, fmt.Errorf("value is %d bytes, expected 8", n)
+	}
+
+	return binary.LittleEndian.Uint64(buf[:]), true, nil
+}
+
+// Count returns the number of records in the database. It's computed from
+// the header alone, without reading any records.
+func (cdb *CDB) Count() int {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	var slots uint64
+	for _, table := range cdb.header {
+		slots += table.length
+	}
+
+	// Each hash table has twice as many slots as it has entries.
+	return int(slots / 2)
+}
+
+// Size returns the size of the database in bytes: the offset just past the
+// last hash table, or past the trailer for files that have one. It's the
+// number of bytes WriteTo writes, and it's known from the header, so it
+// doesn't do any I/O.
+func (cdb *CDB) Size() int64 {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return int64(cdb.end)
+}
+
+// WriteTo writes out the whole database, exactly as it's stored, to w. That
+// makes it possible to copy a database without knowing where it came from,
+// and makes CDB an io.WriterTo.
+func (cdb *CDB) WriteTo(w io.Writer) (int64, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return io.Copy(w, io.NewSectionReader(cdb.reader, 0, int64(cdb.end)))
 }
 
 // Close closes the database to further reads.
 func (cdb *CDB) Close() error {
-	if closer, ok := cdb.reader.(io.Closer); ok {
+	cdb.mu.Lock()
+	defer cdb.mu.Unlock()
+
+	if closer, ok := cdb.reader.(io.Closer); ok && !cdb.shared {
 		return closer.Close()
 	} else {
 		return nil
 	}
 }
 
+// Clone returns an independent CDB for the same database, which can be closed
+// without affecting cdb, and vice versa. It reuses the header cdb has already
+// read, along with its options, so it doesn't read anything from the file.
+//
+// If cdb reads from an *os.File, as it does when opened with Open, the clone
+// opens the file again by name, with its own file descriptor; if the file has
+// since been replaced or removed, Clone returns an error. Any other reader,
+// such as a memory mapping or an in-memory buffer, is shared by the clone,
+// which never closes it, even when switching away from it with Reopen, so the
+// clone must not be used after cdb is closed.
+func (cdb *CDB) Clone() (*CDB, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	reader := cdb.reader
+	shared := true
+	if f, ok := cdb.reader.(*os.File); ok {
+		clone, err := os.Open(f.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			clone.Close()
+			return nil, err
+		}
+
+		cloneInfo, err := clone.Stat()
+		if err != nil {
+			clone.Close()
+			return nil, err
+		} else if !os.SameFile(info, cloneInfo) {
+			clone.Close()
+			return nil, fmt.Errorf("%s has been replaced since it was opened", f.Name())
+		}
+
+		reader = clone
+		shared = false
+	}
+
+	clone := cdb.snapshot()
+	clone.reader = reader
+	clone.shared = shared
+	return clone, nil
+}
+
+// snapshot returns a CDB that reads the database as it is now, sharing its
+// reader, without being affected by a later Reopen. The caller must hold mu.
+func (cdb *CDB) snapshot() *CDB {
+	return &CDB{
+		reader:       cdb.reader,
+		hasher:       cdb.hasher,
+		header:       cdb.header,
+		tableBits:    cdb.tableBits,
+		dataStart:    cdb.dataStart,
+		end:          cdb.end,
+		slotSize:     cdb.slotSize,
+		hashID:       cdb.hashID,
+		flags:        cdb.flags,
+		sections:     cdb.sections,
+		bloom:        cdb.bloom,
+		codec:        cdb.codec,
+		trustHash:    cdb.trustHash,
+		maxValueSize: cdb.maxValueSize,
+		maxProbes:    cdb.maxProbes,
+		shared:       true,
+	}
+}
+
+// Reopen opens the database at path, and switches cdb over to it, closing
+// the file it previously read from. It's meant for deployments that replace
+// a database by renaming a new file over the old one: long-lived readers can
+// pick up the new file without being rebuilt. The new file must have been
+// written with the same hash function as the old one.
+//
+// The switch is atomic with respect to lookups. Any Get, Has or other lookup
+// that's already running when Reopen is called completes against the old
+// file, and Reopen waits for it before closing that file; lookups that start
+// afterwards see only the new one. If the new file can't be opened or read,
+// cdb is left unchanged.
+//
+// That guarantee doesn't extend to anything that outlives a single call:
+// iterators, readers returned by GetReader and slices returned by GetUnsafe
+// refer to the old file, and must not be used once Reopen has returned.
+func (cdb *CDB) Reopen(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	fresh := &CDB{reader: f}
+	err = fresh.readHeader()
+	if err == nil {
+		_, err = fresh.selectHasher(cdb.hasher)
+	}
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	cdb.mu.Lock()
+	old := cdb.reader
+	cdb.reader = fresh.reader
+	cdb.header = fresh.header
+	cdb.tableBits = fresh.tableBits
+	cdb.dataStart = fresh.dataStart
+	cdb.end = fresh.end
+	cdb.slotSize = fresh.slotSize
+	cdb.hashID = fresh.hashID
+	cdb.flags = fresh.flags
+	cdb.sections = fresh.sections
+	cdb.bloom = fresh.bloom
+	cdb.codec = fresh.codec
+	shared := cdb.shared
+	cdb.shared = false
+	cdb.mu.Unlock()
+
+	if closer, ok := old.(io.Closer); ok && !shared {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// hashKey hashes key with a hasher from the pool, so that concurrent lookups
+// never share hasher state.
+func (cdb *CDB) hashKey(key []byte) uint64 {
+	hasher, ok := cdb.hashers.Get().(hash.Hash64)
+	if !ok {
+		hasher = cdb.hasher()
+	}
+
+	hasher.Reset()
+	hasher.Write(key)
+	hash := hasher.Sum64()
+	cdb.hashers.Put(hasher)
+
+	return hash
+}
+
+// selectHasher returns the hash function to read the database with: hasher,
+// if it's not nil, or else the one recorded in the header, or the CDB hash
+// function if none is. If the header records a hash function, hasher must be
+// the same one.
+func (cdb *CDB) selectHasher(hasher HashFunc) (HashFunc, error) {
+	if cdb.hashID == hashUnknown {
+		if hasher == nil {
+			return newCDBHash, nil
+		}
+
+		return hasher, nil
+	}
+
+	builtin, ok := builtinHashes[cdb.hashID]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown hash function %d", ErrHashMismatch, cdb.hashID)
+	} else if hasher == nil {
+		return builtin.fn, nil
+	} else if identifyHash(hasher) != cdb.hashID {
+		return nil, fmt.Errorf("%w: database uses %s", ErrHashMismatch, builtin.name)
+	}
+
+	return hasher, nil
+}
+
+// HashID returns the name of the hash function the database records it was
+// written with: "cdb" for the default, "xxhash" for NewXXHash, or "fnv1a" for
+// NewFNV1a. It returns an empty string if the database doesn't record one,
+// either because it was written with a hash function from outside this
+// package, or because it isn't versioned.
+func (cdb *CDB) HashID() string {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return builtinHashes[cdb.hashID].name
+}
+
 func (cdb *CDB) readHeader() error {
-	buf := make([]byte, headerSize)
+	return cdb.parseHeader(true)
+}
+
+// parseHeader reads and checks the header, like readHeader. If load is false,
+// the contents of the trailer sections, such as the bloom filter, aren't read,
+// and the codec isn't looked up, which leaves cdb only good for checking the
+// layout of the file.
+func (cdb *CDB) parseHeader(load bool) error {
+	// Versioned files start with a preamble; legacy files start directly with
+	// the index.
+	var indexOffset int64
+	var trailerEnd uint64
+	var headerLast bool
+	tables := defaultTables
+
+	// If the reader knows its size, check the header against it up front, so
+	// a truncated file fails here rather than in the middle of a lookup.
+	size, checkSize := readerSize(cdb.reader)
+
+	buf := make([]byte, preambleSize)
 	_, err := cdb.reader.ReadAt(buf, 0)
 	if err != nil {
 		return err
 	}
 
-	for i := 0; i < 256; i++ {
+	if hasMagic(buf) {
+		p, err := parsePreamble(buf)
+		if err != nil {
+			return err
+		}
+
+		if p.tables != 0 {
+			tables = int(p.tables)
+		}
+
+		cdb.hashID = p.hash
+
+		indexOffset = preambleSize
+		if p.flags&flagHeaderLast != 0 {
+			headerLast = true
+			indexOffset, p.trailer, err = cdb.readFooter(size, checkSize, tables)
+			if err != nil {
+				return err
+			}
+		}
+
+		if checkSize && p.trailer > uint64(size) {
+			return fmt.Errorf("%w: trailer at offset %d, file is %d bytes", ErrCorruptHeader, p.trailer, size)
+		}
+
+		if p.trailer != 0 {
+			cdb.sections, trailerEnd, err = readTrailer(cdb.reader, p.trailer)
+			if err != nil {
+				return err
+			}
+
+			if checkSize && trailerEnd > uint64(size) {
+				return fmt.Errorf("%w: trailer ends at offset %d, file is %d bytes", ErrCorruptHeader, trailerEnd, size)
+			}
+		}
+
+		cdb.flags = p.flags
+		if load && p.flags&flagBloom != 0 {
+			cdb.bloom, err = readBloomFilter(cdb.reader, cdb.sections[sectionBloom])
+			if err != nil {
+				return err
+			}
+		}
+
+		if load && p.flags&flagCodec != 0 {
+			cdb.codec, err = lookupCodec(p.codec)
+			if err != nil {
+				return err
+			}
+		}
+
+	}
+
+	buf = make([]byte, tables*16)
+	_, err = cdb.reader.ReadAt(buf, indexOffset)
+	if err != nil {
+		return err
+	}
+
+	cdb.tableBits, _ = tableBits(tables)
+	cdb.header = make([]table, tables)
+	for i := range cdb.header {
 		off := i * 16
 		cdb.header[i] = table{
 			offset: binary.LittleEndian.Uint64(buf[off : off+8]),
@@ -134,30 +1082,323 @@ func (cdb *CDB) readHeader() error {
 		}
 	}
 
+	cdb.dataStart = uint64(indexOffset) + uint64(len(buf))
+	if headerLast {
+		cdb.dataStart = preambleSize
+	}
+
+	cdb.slotSize = 16
+	if cdb.flags&flagHash128 != 0 {
+		cdb.slotSize = 24
+	}
+
+	if !validTables(cdb.header, cdb.dataStart, cdb.slotSize) {
+		return ErrBadMagic
+	}
+
+	cdb.end = cdb.header[0].offset
+	for _, table := range cdb.header {
+		cdb.end += table.length * cdb.slotSize
+	}
+
+	if trailerEnd > cdb.end {
+		cdb.end = trailerEnd
+	}
+
+	if headerLast {
+		cdb.end = uint64(size)
+	}
+
+	if checkSize && cdb.end > uint64(size) {
+		return fmt.Errorf("%w: hash tables end at offset %d, file is %d bytes", ErrCorruptHeader, cdb.end, size)
+	}
+
+	return nil
+}
+
+// readFooter reads the footer at the end of a file written with the header
+// last, and returns the offsets of the index and the trailer.
+func (cdb *CDB) readFooter(size int64, checkSize bool, tables int) (int64, uint64, error) {
+	if !checkSize {
+		return 0, 0, errors.New("the database was written with the header last, so its size must be known; see NewWithSize")
+	}
+
+	indexSize := int64(tables)*16 + 16
+	if size < preambleSize+indexSize {
+		return 0, 0, fmt.Errorf("%w: file is %d bytes, too short for its index", ErrCorruptHeader, size)
+	}
+
+	indexOffset, trailer, err := readTuple(cdb.reader, uint64(size-16))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if indexOffset != uint64(size-indexSize) {
+		return 0, 0, fmt.Errorf("%w: index at offset %d, expected %d", ErrCorruptHeader, indexOffset, size-indexSize)
+	}
+
+	return int64(indexOffset), trailer, nil
+}
+
+// probe walks the hash table slots for the given key, calling match with the
+// data offset of every slot whose hash matches. It stops as soon as match
+// returns true or an error, or when it runs out of slots to check.
+func (cdb *CDB) probe(key []byte, match func(offset uint64) (bool, error)) error {
+	return cdb.probeContext(context.Background(), key, match)
+}
+
+// probeContext is like probe, but checks ctx before reading each slot.
+func (cdb *CDB) probeContext(ctx context.Context, key []byte, match func(offset uint64) (bool, error)) error {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return cdb.probeLocked(ctx, key, match)
+}
+
+// probeLocked is like probeContext, but expects the caller to hold mu.
+func (cdb *CDB) probeLocked(ctx context.Context, key []byte, match func(offset uint64) (bool, error)) error {
+	return cdb.probeHash(ctx, cdb.hashKey(key), cdb.keyCheck(key), match)
+}
+
+// probeHash is like probeLocked, but takes the hashes of the key rather than
+// the key itself: its hash, and its check word from keyCheck.
+func (cdb *CDB) probeHash(ctx context.Context, hash, check uint64, match func(offset uint64) (bool, error)) error {
+	table := cdb.header[tableFor(hash, cdb.tableBits)]
+	if table.length == 0 {
+		return nil
+	}
+
+	// The bloom filter can rule out most missing keys without any I/O.
+	if cdb.bloom != nil && !cdb.bloom.mayContain(hash) {
+		return nil
+	}
+
+	// Probe the given hash table, starting at the given slot.
+	startingSlot := firstSlot(hash, cdb.tableBits, table.length)
+	slot := startingSlot
+
+	for probes := 0; ; probes++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if cdb.maxProbes > 0 && probes == cdb.maxProbes {
+			return fmt.Errorf("%w: gave up after %d slots", ErrProbeLimitExceeded, probes)
+		}
+
+		slotOffset := table.offset + (cdb.slotSize * slot)
+		slotHash, offset, slotCheck, err := cdb.readSlot(slotOffset)
+		if err != nil {
+			return err
+		}
+
+		// An empty slot means the key doesn't exist. Empty slots have a zero
+		// offset; their hash is zero too, but so is the hash of some keys.
+		if offset == 0 {
+			break
+		} else if slotHash == hash && slotCheck == check {
+			found, err := match(offset)
+			if err != nil || found {
+				return err
+			}
+		}
+
+		slot = (slot + 1) % table.length
+		if slot == startingSlot {
+			break
+		}
+	}
+
 	return nil
 }
 
-func (cdb *CDB) getValueAt(offset uint64, expectedKey []byte) ([]byte, error) {
+// readSlot reads the hash table slot at slotOffset, returning the hash and
+// record offset stored in it, and the check word, or zero if the database
+// wasn't written with WriterOptions.Hash128.
+func (cdb *CDB) readSlot(slotOffset uint64) (uint64, uint64, uint64, error) {
+	if cdb.slotSize == 16 {
+		hash, offset, err := readTuple(cdb.reader, slotOffset)
+		return hash, offset, 0, err
+	}
+
+	buf, err := readAt(cdb.reader, slotOffset, 24, nil)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	hash := binary.LittleEndian.Uint64(buf[0:8])
+	offset := binary.LittleEndian.Uint64(buf[8:16])
+	check := binary.LittleEndian.Uint64(buf[16:24])
+	return hash, offset, check, nil
+}
+
+// keyCheck returns the check word stored alongside key's hash, or zero if the
+// database wasn't written with WriterOptions.Hash128.
+func (cdb *CDB) keyCheck(key []byte) uint64 {
+	if cdb.slotSize == 16 {
+		return 0
+	}
+
+	return checkHash(key)
+}
+
+// RecordAt returns the key and value of the record that starts at offset,
+// bypassing the hash tables. It's meant for building other ways of finding
+// records, on top of offsets captured from HashIterator.Offset, for example.
+// The offset must be the start of a record; it's checked to be within the data
+// section, but an offset into the middle of a record returns garbage or an
+// error.
+func (cdb *CDB) RecordAt(offset uint64) ([]byte, []byte, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	if offset < cdb.dataStart || offset >= cdb.header[0].offset {
+		return nil, nil, fmt.Errorf("offset %d is outside the data section", offset)
+	}
+
+	key, stored, err := cdb.readRecord(offset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value, err := cdb.decode(key, stored)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, value, nil
+}
+
+// readRecord reads the whole record at offset, returning its key, and its
+// value as it's stored, before decoding.
+func (cdb *CDB) readRecord(offset uint64) ([]byte, []byte, error) {
 	keyLength, valueLength, err := readTuple(cdb.reader, offset)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// We can compare key lengths before reading the key at all.
-	if int(keyLength) != len(expectedKey) {
-		return nil, nil
+	_, err = cdb.recordEnd(offset, keyLength, valueLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = cdb.checkValueSize(offset, valueLength)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	buf := make([]byte, keyLength+valueLength)
 	_, err = cdb.reader.ReadAt(buf, int64(offset+16))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// If they keys don't match, this isn't it.
-	if bytes.Compare(buf[:keyLength], expectedKey) != 0 {
-		return nil, nil
+	return buf[:keyLength], buf[keyLength:], nil
+}
+
+// recordEnd returns the offset just past a record with the given key and
+// value lengths, or an error if it doesn't fit in the data section. Lengths
+// are checked before anything is allocated for them, since a corrupt file can
+// claim any size it likes.
+func (cdb *CDB) recordEnd(offset, keyLength, valueLength uint64) (uint64, error) {
+	dataEnd := cdb.header[0].offset
+	if offset > dataEnd || dataEnd-offset < 16 ||
+		keyLength > dataEnd-offset-16 || valueLength > dataEnd-offset-16-keyLength {
+		return 0, fmt.Errorf("%w: record at offset %d runs past the start of the hash tables", ErrCorrupt, offset)
+	}
+
+	return offset + 16 + keyLength + valueLength, nil
+}
+
+// checkValueSize returns ErrValueTooLarge if the record at offset has a value
+// larger than ReaderOptions.MaxValueSize.
+func (cdb *CDB) checkValueSize(offset, valueLength uint64) error {
+	if cdb.maxValueSize > 0 && valueLength > uint64(cdb.maxValueSize) {
+		return fmt.Errorf("%w: record at offset %d has a %d byte value", ErrValueTooLarge, offset, valueLength)
+	}
+
+	return nil
+}
+
+// getValueAt returns the value of the record at offset, or nil if its key
+// isn't expectedKey. The key is read and compared first, so that the value is
+// only read for the matching record. If alias is false, the value is always a
+// fresh copy; otherwise it may point into the reader's own memory.
+func (cdb *CDB) getValueAt(offset uint64, expectedKey []byte, alias bool) ([]byte, error) {
+	value, _, err := cdb.getRecordAt(offset, expectedKey, alias)
+	return value, err
+}
+
+// getRecordAt is like getValueAt, but also returns the record's expiry time,
+// in unix seconds, or zero if it has none.
+func (cdb *CDB) getRecordAt(offset uint64, expectedKey []byte, alias bool) ([]byte, int64, error) {
+	valueLength, ok, err := cdb.matchKey(offset, expectedKey, nil)
+	if err != nil || !ok {
+		return nil, 0, err
+	}
+
+	_, err = cdb.recordEnd(offset, uint64(len(expectedKey)), valueLength)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = cdb.checkValueSize(offset, valueLength)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	valueOffset := offset + 16 + uint64(len(expectedKey))
+	value, err := readAt(cdb.reader, valueOffset, valueLength, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	value, expires, err := cdb.decodeRecord(expectedKey, value)
+	if err != nil || cdb.codec != nil {
+		// Decoding with a codec already made a copy.
+		return value, expires, err
+	}
+
+	// Memory-backed readers return their own memory, which the caller
+	// mustn't be able to modify or outlive.
+	if _, ok := cdb.reader.(byteSlicer); ok && !alias {
+		value = append(make([]byte, 0, len(value)), value...)
+	}
+
+	return value, expires, nil
+}
+
+// matchKey is like matchKeyAt for the database's reader, but if the database
+// trusts hashes, keys of the right length always match.
+func (cdb *CDB) matchKey(offset uint64, expectedKey, scratch []byte) (uint64, bool, error) {
+	if !cdb.trustHash {
+		return matchKeyAt(cdb.reader, offset, expectedKey, scratch)
+	}
+
+	keyLength, valueLength, err := readTuple(cdb.reader, offset)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return valueLength, int(keyLength) == len(expectedKey), nil
+}
+
+// matchKeyAt reads the header of the record at offset in r, and compares the
+// stored key against expectedKey, without reading the value. It returns the
+// length of the value, and whether the keys match. If scratch has enough
+// capacity, it's used to hold the stored key instead of allocating.
+func matchKeyAt(r io.ReaderAt, offset uint64, expectedKey, scratch []byte) (uint64, bool, error) {
+	keyLength, valueLength, err := readTuple(r, offset)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if int(keyLength) != len(expectedKey) {
+		return 0, false, nil
+	}
+
+	buf, err := readAt(r, offset+16, keyLength, scratch)
+	if err != nil {
+		return 0, false, err
 	}
 
-	return buf[keyLength:], nil
+	return valueLength, bytes.Equal(buf, expectedKey), nil
 }
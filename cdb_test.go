@@ -1,10 +1,19 @@
 package cdb64
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"strconv"
 	"sync"
 	"syscall"
 	"testing"
@@ -71,6 +80,813 @@ func TestGetParallel(t *testing.T) {
 	}
 }
 
+func TestGetString(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	require.NotNil(t, db)
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+
+		value, found, err := db.GetString(string(record[0]))
+		require.NoError(t, err, msg)
+		assert.Equal(t, record[1] != nil, found, msg)
+		assert.Equal(t, string(record[1]), value, msg)
+	}
+}
+
+func TestGetOK(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+
+		value, ok, err := db.GetOK(record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, record[1] != nil, ok, msg)
+		assert.Equal(t, string(record[1]), string(value), msg)
+	}
+
+	value, ok, err := db.GetOK([]byte("empty_value"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, value)
+}
+
+func TestGetWithHash(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, record := range expectedRecords {
+		hasher := newCDBHash()
+		hasher.Write(record[0])
+
+		value, err := db.GetWithHash(record[0], hasher.Sum64())
+		require.NoError(t, err)
+		assert.Equal(t, record[1], value)
+	}
+
+	// The key is still checked, even if the hash matches.
+	hasher := newCDBHash()
+	hasher.Write([]byte("foo"))
+	value, err := db.GetWithHash([]byte("bar"), hasher.Sum64())
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestSizeOf(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, record := range expectedRecords {
+		size, found, err := db.SizeOf(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, record[1] != nil, found)
+		assert.Equal(t, int64(len(record[1])), size)
+	}
+
+	for _, opts := range []WriterOptions{{RecordChecksums: true, Expiry: true}, {Codec: GzipCodec{}}} {
+		var buf Buffer
+		writer, err := NewWriterWithOptions(&buf, opts)
+		require.NoError(t, err)
+		require.NoError(t, writer.Put([]byte("foo"), []byte("hello")))
+
+		db, err := writer.Freeze()
+		require.NoError(t, err)
+
+		size, found, err := db.SizeOf([]byte("foo"))
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, int64(5), size)
+	}
+}
+
+func TestHas(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	require.NotNil(t, db)
+
+	for _, record := range expectedRecords {
+		msg := "while checking " + string(record[0])
+
+		found, err := db.Has(record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, record[1] != nil, found, msg)
+	}
+}
+
+func TestGetReader(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	require.NotNil(t, db)
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+
+		r, n, err := db.GetReader(record[0])
+		require.NoError(t, err, msg)
+		if record[1] == nil {
+			assert.Nil(t, r, msg)
+			continue
+		}
+
+		require.NotNil(t, r, msg)
+		assert.Equal(t, int64(len(record[1])), n, msg)
+
+		value, err := ioutil.ReadAll(r)
+		require.NoError(t, err, msg)
+		assert.Equal(t, string(record[1]), string(value), msg)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	require.NotNil(t, db)
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+
+		// Start with a buffer that's too small for most values.
+		buf := make([]byte, 1)
+		n, found, err := db.GetInto(record[0], buf)
+		require.NoError(t, err, msg)
+		assert.Equal(t, record[1] != nil, found, msg)
+		assert.Equal(t, len(record[1]), n, msg)
+
+		if n > cap(buf) {
+			buf = make([]byte, n)
+			n, found, err = db.GetInto(record[0], buf)
+			require.NoError(t, err, msg)
+			assert.True(t, found, msg)
+		}
+
+		assert.Equal(t, string(record[1]), string(buf[:n]), msg)
+	}
+}
+
+func TestGetIntoCorruptLength(t *testing.T) {
+	var buf Buffer
+	writer, err := NewWriter(&buf, nil)
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	// Claim a value far larger than the file.
+	data := append([]byte(nil), buf.Bytes()...)
+	binary.LittleEndian.PutUint64(data[headerSize+8:], 1<<63+5)
+	db, err := FromBytes(data, nil)
+	require.NoError(t, err)
+
+	_, _, err = db.GetInto([]byte("foo"), make([]byte, 16))
+	assert.True(t, errors.Is(err, ErrCorrupt), "got %v", err)
+
+	// GetInto honors MaxValueSize, like Get.
+	db, err = NewWithOptions(&buf, ReaderOptions{MaxValueSize: 2})
+	require.NoError(t, err)
+
+	_, _, err = db.GetInto([]byte("foo"), make([]byte, 16))
+	assert.True(t, errors.Is(err, ErrValueTooLarge), "got %v", err)
+}
+
+func TestGetContext(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	require.NotNil(t, db)
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+
+		value, err := db.GetContext(context.Background(), record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, string(record[1]), string(value), msg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, record := range expectedRecords {
+		value, err := db.GetContext(ctx, record[0])
+		assert.Nil(t, value)
+
+		// Keys whose hash table is empty never need to touch the reader.
+		if db.header[db.hashKey(record[0])&0xff].length > 0 {
+			assert.Equal(t, context.Canceled, err)
+		}
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	keys := make([][]byte, 0, len(expectedRecords)*2)
+	for _, record := range expectedRecords {
+		keys = append(keys, record[0])
+	}
+
+	// Duplicates are fine, and get the same value.
+	keys = append(keys, keys...)
+	values, err := db.MultiGet(keys)
+	require.NoError(t, err)
+	require.Len(t, values, len(keys))
+
+	for i, key := range keys {
+		expected, err := db.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, values[i], "while fetching "+string(key))
+	}
+
+	values, err = db.MultiGet(nil)
+	require.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func TestGetPacked(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	keys := make([][]byte, 0, len(expectedRecords))
+	for _, record := range expectedRecords {
+		keys = append(keys, record[0])
+	}
+
+	data, offsets, err := db.GetPacked(keys)
+	require.NoError(t, err)
+	require.Len(t, offsets, len(keys)+1)
+	assert.Equal(t, len(data), offsets[len(keys)])
+
+	for i, record := range expectedRecords {
+		if record[1] == nil {
+			assert.Equal(t, MissingValue, offsets[i], "while fetching "+string(record[0]))
+			continue
+		}
+
+		end := i + 1
+		for offsets[end] == MissingValue {
+			end++
+		}
+
+		assert.Equal(t, string(record[1]), string(data[offsets[i]:offsets[end]]), "while fetching "+string(record[0]))
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+
+	// 'playwright' and 'snush' collide in cdbhash, so they share a probe
+	// sequence.
+	require.NoError(t, writer.Put([]byte("playwright"), []byte("1")))
+	require.NoError(t, writer.Put([]byte("snush"), []byte("2")))
+	require.NoError(t, writer.Put([]byte("playwright"), []byte("3")))
+	require.NoError(t, writer.Put([]byte("foo"), []byte("4")))
+	require.NoError(t, writer.Put([]byte("playwright"), []byte("")))
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+
+	values, err := db.GetAll([]byte("playwright"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("3"), []byte("")}, values)
+
+	values, err = db.GetAll([]byte("snush"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("2")}, values)
+
+	values, err = db.GetAll([]byte("not in the table"))
+	require.NoError(t, err)
+	assert.Nil(t, values)
+
+	value, err := db.GetFirst([]byte("playwright"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+
+	value, err = db.GetLast([]byte("playwright"))
+	require.NoError(t, err)
+	assert.NotNil(t, value)
+	assert.Equal(t, "", string(value))
+
+	value, err = db.GetLast([]byte("snush"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(value))
+
+	value, err = db.GetFirst([]byte("not in the table"))
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func testGetConcurrentDistinctKeys(t *testing.T, hasher HashFunc) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, hasher)
+	require.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(-i))))
+	}
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+
+	// Each goroutine walks the keys from a different starting point, so that
+	// lookups for distinct keys are constantly interleaved.
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				i := (j*16 + g) % 1000
+				value, err := db.Get([]byte(strconv.Itoa(i)))
+				assert.NoError(t, err)
+				assert.Equal(t, strconv.Itoa(-i), string(value))
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestGetConcurrentDistinctKeys(t *testing.T) {
+	testGetConcurrentDistinctKeys(t, nil)
+}
+
+func TestGetConcurrentDistinctKeysFnv(t *testing.T) {
+	testGetConcurrentDistinctKeys(t, fnv.New64a)
+}
+
+func TestCount(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	require.NotNil(t, db)
+
+	assert.Equal(t, len(expectedRecords)-1, db.Count())
+}
+
+func TestFromBytes(t *testing.T) {
+	data, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+
+	db, err := FromBytes(data, nil)
+	require.NoError(t, err)
+	require.NotNil(t, db)
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+
+		value, err := db.Get(record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, string(record[1]), string(value), msg)
+	}
+
+	_, err = FromBytes(data[:100], nil)
+	assert.Error(t, err)
+}
+
+func TestRecordAt(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	iter := db.IterHashes()
+	for iter.Next() {
+		key, value, err := db.RecordAt(iter.Offset())
+		require.NoError(t, err)
+
+		expected, err := db.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(value))
+	}
+	require.NoError(t, iter.Err())
+
+	key, value, err := db.RecordAt(headerSize)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(key))
+	assert.Equal(t, "bar", string(value))
+
+	_, _, err = db.RecordAt(0)
+	assert.Error(t, err)
+	_, _, err = db.RecordAt(uint64(db.Size()))
+	assert.Error(t, err)
+}
+
+func TestTruncatedHeader(t *testing.T) {
+	data, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+
+	_, err = FromBytes(data[:len(data)-16], nil)
+	assert.True(t, errors.Is(err, ErrCorruptHeader), "got %v", err)
+
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data[:headerSize+10])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = Open(f.Name())
+	assert.True(t, errors.Is(err, ErrCorruptHeader), "got %v", err)
+
+	_, err = OpenMmap(f.Name())
+	assert.True(t, errors.Is(err, ErrCorruptHeader), "got %v", err)
+}
+
+func TestNewWithSize(t *testing.T) {
+	data, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+
+	// Hide the size of the reader, as a ranged reader for object storage would.
+	reader := struct{ io.ReaderAt }{bytes.NewReader(data)}
+
+	db, err := NewWithSize(reader, int64(len(data)), nil)
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords {
+		value, err := db.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, record[1], value)
+	}
+
+	var buf bytes.Buffer
+	_, err = db.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, data, buf.Bytes())
+	assert.Equal(t, int64(len(data)), db.Size())
+
+	_, err = NewWithSize(reader, int64(len(data)-16), nil)
+	assert.True(t, errors.Is(err, ErrCorruptHeader), "got %v", err)
+}
+
+func TestIsCDB(t *testing.T) {
+	ok, err := IsCDB("./test/test.cdb")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	var buf Buffer
+	writer, err := NewWriterWithOptions(&buf, WriterOptions{Versioned: true, Hash128: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	ok, err = IsCDBReader(&buf)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// A database with a codec that isn't registered is still a database.
+	buf = Buffer{}
+	writer, err = NewWriterWithOptions(&buf, WriterOptions{Codec: unregisteredCodec{}, BloomFalsePositiveRate: 0.01})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	_, err = New(&buf, nil)
+	require.True(t, errors.Is(err, ErrUnknownCodec), "got %v", err)
+	ok, err = IsCDBReader(&buf)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	data, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+
+	for _, notCDB := range [][]byte{nil, []byte("hello, world"), bytes.Repeat([]byte{0xff}, 8192), data[:len(data)-16]} {
+		ok, err := IsCDBReader(bytes.NewReader(notCDB))
+		require.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	_, err = IsCDB("./test/missing.cdb")
+	assert.True(t, os.IsNotExist(err), "got %v", err)
+}
+
+func TestNewAt(t *testing.T) {
+	data, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+
+	container := append(bytes.Repeat([]byte("header"), 100), data...)
+	base := int64(len(container) - len(data))
+	container = append(container, "trailing data"...)
+
+	db, err := NewAt(bytes.NewReader(container), base, nil)
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords {
+		value, err := db.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, record[1], value)
+	}
+
+	var buf bytes.Buffer
+	_, err = db.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, data, buf.Bytes())
+
+	_, err = NewAt(bytes.NewReader(container), int64(len(container)+1), nil)
+	assert.True(t, errors.Is(err, ErrCorruptHeader), "got %v", err)
+}
+
+// constantHash hashes every key the same, so that all of them collide.
+type constantHash struct {
+	hash.Hash64
+}
+
+func newConstantHash() hash.Hash64 {
+	return constantHash{newCDBHash()}
+}
+
+func (h constantHash) Sum64() uint64 {
+	return 42
+}
+
+func TestTrustHash(t *testing.T) {
+	f, err := os.Open("./test/test.cdb")
+	require.NoError(t, err)
+
+	db, err := NewWithOptions(f, ReaderOptions{TrustHash: true})
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, record := range expectedRecords {
+		value, err := db.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, record[1], value)
+	}
+
+	// With keys that collide, only the length of the key tells them apart.
+	tmp, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(tmp.Name())
+
+	writer, err := NewWriter(tmp, newConstantHash)
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("aaaa"), []byte("a")))
+	collided, err := writer.Freeze()
+	require.NoError(t, err)
+	defer collided.Close()
+
+	trusting, err := NewWithOptions(collided.reader, ReaderOptions{Hasher: newConstantHash, TrustHash: true})
+	require.NoError(t, err)
+
+	value, err := trusting.Get([]byte("bbbb"))
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(value))
+
+	value, err = trusting.Get([]byte("bbb"))
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	value, err = collided.Get([]byte("bbbb"))
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestMaxValueSize(t *testing.T) {
+	var buf Buffer
+	writer, err := NewWriter(&buf, nil)
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("small"), []byte("1234")))
+	require.NoError(t, writer.Put([]byte("large"), []byte("12345")))
+	require.NoError(t, writer.Close())
+
+	db, err := NewWithOptions(&buf, ReaderOptions{MaxValueSize: 4})
+	require.NoError(t, err)
+
+	value, err := db.Get([]byte("small"))
+	require.NoError(t, err)
+	assert.Equal(t, "1234", string(value))
+
+	_, err = db.Get([]byte("large"))
+	assert.True(t, errors.Is(err, ErrValueTooLarge), "got %v", err)
+
+	iter := db.Iter()
+	require.True(t, iter.Next())
+	assert.False(t, iter.Next())
+	assert.True(t, errors.Is(iter.Err(), ErrValueTooLarge), "got %v", iter.Err())
+
+	// Without a limit, any size goes.
+	db, err = New(&buf, nil)
+	require.NoError(t, err)
+
+	value, err = db.Get([]byte("large"))
+	require.NoError(t, err)
+	assert.Equal(t, "12345", string(value))
+}
+
+func TestMaxProbes(t *testing.T) {
+	var buf Buffer
+	writer, err := NewWriter(&buf, newConstantHash)
+	require.NoError(t, err)
+
+	// Every key collides, so each one is a slot further along than the last.
+	for i := 0; i < 10; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("value")))
+	}
+
+	require.NoError(t, writer.Close())
+
+	db, err := NewWithOptions(&buf, ReaderOptions{Hasher: newConstantHash, MaxProbes: 3})
+	require.NoError(t, err)
+
+	value, err := db.Get([]byte("2"))
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(value))
+
+	for _, key := range []string{"3", "missing"} {
+		_, err = db.Get([]byte(key))
+		assert.True(t, errors.Is(err, ErrProbeLimitExceeded), "got %v", err)
+	}
+
+	// Without a limit, lookups probe as far as they need to.
+	db, err = New(&buf, newConstantHash)
+	require.NoError(t, err)
+
+	value, err = db.Get([]byte("9"))
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(value))
+}
+
+func TestWriteTo(t *testing.T) {
+	data, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	n, err := db.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, buf.Bytes())
+	assert.Equal(t, int64(len(data)), db.Size())
+
+	// Versioned files end with a trailer, which has to be copied too.
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Checksum: true, BloomFalsePositiveRate: 0.01})
+	require.NoError(t, err)
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		require.NoError(t, writer.Put(record[0], record[1]))
+	}
+	require.NoError(t, writer.Close())
+
+	data, err = ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+
+	db, err = Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	buf.Reset()
+	n, err = db.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, int64(len(data)), db.Size())
+	assert.Equal(t, data, buf.Bytes())
+
+	copied, err := FromBytes(buf.Bytes(), nil)
+	require.NoError(t, err)
+	require.NoError(t, copied.VerifyChecksum())
+}
+
+func TestReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-cdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	create := func(path, value string) {
+		writer, err := Create(path)
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte(value)))
+		}
+		require.NoError(t, writer.Close())
+	}
+
+	path := dir + "/test.cdb"
+	create(path, "old")
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Keep lookups running during the swap; each must see one file or the
+	// other in full.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				value, err := db.Get([]byte("42"))
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Contains(t, []string{"old", "new"}, string(value))
+			}
+		}()
+	}
+
+	// Scans with ForEach and Keys also see a single file throughout.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			var first string
+			err := db.ForEach(func(key, value []byte) error {
+				if first == "" {
+					first = string(value)
+				}
+
+				assert.Equal(t, first, string(value))
+				return nil
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			keys, err := db.Keys()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Len(t, keys, 100)
+		}
+	}()
+
+	// Iterators may fail once Reopen closes the file they read from, but
+	// they mustn't race with the swap.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			iter := db.Iter()
+			for iter.Next() {
+			}
+
+			keys := db.IterKeys()
+			for keys.Next() {
+			}
+
+			hashes := db.IterHashes()
+			for hashes.Next() {
+			}
+		}
+	}()
+
+	create(dir+"/next.cdb", "new")
+	require.NoError(t, os.Rename(dir+"/next.cdb", path))
+	require.NoError(t, db.Reopen(path))
+
+	close(done)
+	wg.Wait()
+
+	value, err := db.Get([]byte("42"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(value))
+
+	// A failed reopen leaves the database as it was.
+	assert.Error(t, db.Reopen(dir+"/missing.cdb"))
+	require.NoError(t, ioutil.WriteFile(dir+"/bad.cdb", make([]byte, 100), 0644))
+	assert.Error(t, db.Reopen(dir+"/bad.cdb"))
+
+	value, err = db.Get([]byte("42"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(value))
+}
+
 func TestClosesFile(t *testing.T) {
 	f, err := os.Open("./test/test.cdb")
 	require.NoError(t, err)
@@ -88,6 +904,20 @@ func TestClosesFile(t *testing.T) {
 
 func BenchmarkGet(b *testing.B) {
 	db, _ := Open("./test/test.cdb")
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	rand.Seed(time.Now().UnixNano())
+	for i := 0; i < b.N; i++ {
+		record := expectedRecords[rand.Intn(len(expectedRecords))]
+		db.Get(record[0])
+	}
+}
+
+func BenchmarkGetFromBytes(b *testing.B) {
+	data, _ := ioutil.ReadFile("./test/test.cdb")
+	db, _ := FromBytes(data, nil)
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	rand.Seed(time.Now().UnixNano())
@@ -147,3 +977,175 @@ func shuffle(a [][][]byte) {
 		a[i], a[j] = a[j], a[i]
 	}
 }
+
+func TestGetZeroHash(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, newZeroHash)
+	require.NoError(t, err)
+
+	// "zero" hashes to 0 with newZeroHash; surround it with other keys in the
+	// same hash table.
+	require.NoError(t, writer.Put([]byte("zero"), []byte("value")))
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i))))
+	}
+	require.NoError(t, writer.Close())
+
+	f2, err := os.Open(f.Name())
+	require.NoError(t, err)
+	db, err := New(f2, newZeroHash)
+	require.NoError(t, err)
+	defer db.Close()
+
+	value, err := db.Get([]byte("zero"))
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(value))
+
+	for i := 0; i < 1000; i++ {
+		value, err := db.Get([]byte(strconv.Itoa(i)))
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(value))
+	}
+
+	require.NoError(t, db.Verify())
+
+	n := 0
+	iter := db.IterHashes()
+	for iter.Next() {
+		n++
+	}
+	require.NoError(t, iter.Err())
+	assert.Equal(t, 1001, n)
+}
+
+func TestEmptyDatabase(t *testing.T) {
+	for name, opts := range map[string]WriterOptions{
+		"legacy":     {},
+		"versioned":  {Versioned: true},
+		"all":        {Checksum: true, BloomFalsePositiveRate: 0.01, SortedKeys: true, RecordChecksums: true, Hash128: true},
+		"headerLast": {HeaderLast: true},
+		"oneTable":   {TableCount: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf Buffer
+			writer, err := NewWriterWithOptions(&buf, opts)
+			require.NoError(t, err)
+			db, err := writer.Freeze()
+			require.NoError(t, err)
+
+			for _, table := range db.header {
+				assert.Zero(t, table.length)
+			}
+
+			require.NoError(t, db.Verify())
+			assert.Equal(t, 0, db.Count())
+
+			for _, key := range [][]byte{nil, {}, []byte("foo"), expectedRecords[0][0]} {
+				value, err := db.Get(key)
+				require.NoError(t, err)
+				assert.Nil(t, value)
+
+				values, err := db.GetAll(key)
+				require.NoError(t, err)
+				assert.Empty(t, values)
+
+				_, ok, err := db.SizeOf(key)
+				require.NoError(t, err)
+				assert.False(t, ok)
+			}
+
+			values, err := db.MultiGet([][]byte{[]byte("foo"), []byte("bar")})
+			require.NoError(t, err)
+			assert.Equal(t, [][]byte{nil, nil}, values)
+
+			for _, iter := range []*Iterator{db.Iter(), db.IterByTable()} {
+				assert.False(t, iter.Next())
+				require.NoError(t, iter.Err())
+			}
+
+			keys, err := db.Keys()
+			require.NoError(t, err)
+			assert.Empty(t, keys)
+
+			probes, err := db.AverageProbeLength()
+			require.NoError(t, err)
+			assert.Zero(t, probes)
+		})
+	}
+}
+
+func TestSingleRecordDatabase(t *testing.T) {
+	for name, opts := range map[string]WriterOptions{
+		"legacy":   {},
+		"all":      {Checksum: true, BloomFalsePositiveRate: 0.01, SortedKeys: true, RecordChecksums: true, Hash128: true},
+		"oneTable": {TableCount: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf Buffer
+			writer, err := NewWriterWithOptions(&buf, opts)
+			require.NoError(t, err)
+			require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+			db, err := writer.Freeze()
+			require.NoError(t, err)
+
+			require.NoError(t, db.Verify())
+			assert.Equal(t, 1, db.Count())
+
+			value, err := db.Get([]byte("foo"))
+			require.NoError(t, err)
+			assert.Equal(t, "bar", string(value))
+
+			for _, key := range []string{"", "fo", "foo\x00", "bar"} {
+				value, err := db.Get([]byte(key))
+				require.NoError(t, err)
+				assert.Nil(t, value)
+			}
+
+			probes, err := db.AverageProbeLength()
+			require.NoError(t, err)
+			assert.Equal(t, 1.0, probes)
+		})
+	}
+}
+
+func TestNewNilReader(t *testing.T) {
+	_, err := New(nil, nil)
+	assert.Equal(t, ErrNilReader, err)
+
+	_, err = NewWithSize(nil, 0, nil)
+	assert.Equal(t, ErrNilReader, err)
+}
+
+func TestClone(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+
+	clone, err := db.Clone()
+	require.NoError(t, err)
+	defer clone.Close()
+
+	// Closing the original leaves the clone's file open.
+	require.NoError(t, db.Close())
+	for _, record := range expectedRecords {
+		value, err := clone.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, string(record[1]), string(value))
+	}
+
+	// A clone of a memory-mapped database shares the mapping, and closing
+	// the clone doesn't unmap it.
+	mapped, err := OpenMmap("./test/test.cdb")
+	require.NoError(t, err)
+	defer mapped.Close()
+
+	mappedClone, err := mapped.Clone()
+	require.NoError(t, err)
+	require.NoError(t, mappedClone.Close())
+
+	value, err := mapped.Get([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+}
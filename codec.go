@@ -0,0 +1,155 @@
+package cdb64
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// A ValueCodec transforms values on their way into and out of a database, for
+// example to compress them. Keys are never encoded, so that hashing and
+// lookups work the same way.
+//
+// The codec used to write a database is recorded in its header by ID, and
+// readers select it automatically, so custom codecs must be registered with
+// RegisterCodec before opening any databases that use them.
+type ValueCodec interface {
+	// ID identifies the codec in the file header. IDs below 256 are reserved
+	// for codecs in this package.
+	ID() uint32
+
+	// Encode returns the encoded form of value.
+	Encode(value []byte) []byte
+
+	// Decode reverses Encode.
+	Decode(data []byte) ([]byte, error)
+}
+
+// ErrUnknownCodec is returned when opening a database written with a codec
+// that hasn't been registered.
+var ErrUnknownCodec = errors.New("database uses an unknown value codec")
+
+const codecGzip = 1
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[uint32]ValueCodec{
+		codecGzip: GzipCodec{},
+	}
+)
+
+// RegisterCodec makes a codec available for reading databases written with
+// it. It panics if another codec is already registered with the same ID.
+func RegisterCodec(codec ValueCodec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if _, ok := codecs[codec.ID()]; ok {
+		panic(fmt.Sprintf("cdb64: codec %d registered twice", codec.ID()))
+	}
+
+	codecs[codec.ID()] = codec
+}
+
+func lookupCodec(id uint32) (ValueCodec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+
+	return codec, nil
+}
+
+// GzipCodec compresses values with gzip. It's registered by default.
+type GzipCodec struct {
+	// Level is the compression level, as for compress/gzip. Zero means the
+	// default level.
+	Level int
+}
+
+// ID implements ValueCodec.
+func (c GzipCodec) ID() uint32 {
+	return codecGzip
+}
+
+// Encode implements ValueCodec.
+func (c GzipCodec) Encode(value []byte) []byte {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		w = gzip.NewWriter(&buf)
+	}
+
+	w.Write(value)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Decode implements ValueCodec.
+func (c GzipCodec) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+// decode turns the stored value of the record for key back into the value
+// that was written: it checks and strips the record's checksum, if there is
+// one, then decodes it with the database's codec, if it has one.
+func (cdb *CDB) decode(key, data []byte) ([]byte, error) {
+	value, _, err := cdb.decodeRecord(key, data)
+	return value, err
+}
+
+// decodeRecord is like decode, but also returns the record's expiry time, in
+// unix seconds, or zero if it has none.
+func (cdb *CDB) decodeRecord(key, data []byte) ([]byte, int64, error) {
+	var err error
+	if cdb.flags&flagRecordChecksum != 0 {
+		data, err = checkRecord(key, data)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var expires int64
+	if cdb.flags&flagExpiry != 0 {
+		data, expires, err = splitExpiry(key, data)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if cdb.codec == nil {
+		return data, expires, nil
+	}
+
+	value, err := cdb.codec.Decode(data)
+	if err != nil {
+		return nil, 0, err
+	} else if value == nil {
+		// A nil value would look like a missing key.
+		value = []byte{}
+	}
+
+	return value, expires, nil
+}
+
+// transformsValues reports whether stored values differ from the values that
+// were written, in which case they have to be read whole and decoded.
+func (cdb *CDB) transformsValues() bool {
+	return cdb.codec != nil || cdb.flags&(flagRecordChecksum|flagExpiry) != 0
+}
@@ -0,0 +1,135 @@
+package cdb64
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reverseCodec is a trivial codec used to check that custom codecs are
+// picked up from the registry.
+type reverseCodec struct{}
+
+func (reverseCodec) ID() uint32 { return 1000 }
+
+func (reverseCodec) Encode(value []byte) []byte {
+	encoded := make([]byte, len(value))
+	for i, b := range value {
+		encoded[len(value)-1-i] = b
+	}
+
+	return encoded
+}
+
+func (c reverseCodec) Decode(data []byte) ([]byte, error) {
+	return c.Encode(data), nil
+}
+
+func init() {
+	RegisterCodec(reverseCodec{})
+}
+
+func TestGzipCodec(t *testing.T) {
+	value := bytes.Repeat([]byte("compressible "), 100)
+
+	encoded := GzipCodec{}.Encode(value)
+	assert.True(t, len(encoded) < len(value))
+
+	decoded, err := GzipCodec{}.Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, value, decoded)
+
+	_, err = GzipCodec{}.Decode([]byte("not gzip"))
+	assert.Error(t, err)
+}
+
+func TestWritesReadableCodec(t *testing.T) {
+	for _, codec := range []ValueCodec{GzipCodec{}, GzipCodec{Level: 9}, reverseCodec{}} {
+		f, err := ioutil.TempFile("", "test-cdb")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+
+		writer, err := NewWriterWithOptions(f, WriterOptions{Codec: codec})
+		require.NoError(t, err)
+
+		testWritesReadable(t, writer)
+	}
+}
+
+func TestCodecValues(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Codec: GzipCodec{}})
+	require.NoError(t, err)
+
+	big := bytes.Repeat([]byte("abc"), 10000)
+	require.NoError(t, writer.Put([]byte("big"), big))
+	require.NoError(t, writer.Put([]byte("empty"), []byte{}))
+	require.NoError(t, writer.Close())
+
+	stat, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	assert.True(t, stat.Size() < int64(len(big)))
+
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	value, err := db.Get([]byte("big"))
+	require.NoError(t, err)
+	assert.Equal(t, big, value)
+
+	value, err = db.Get([]byte("empty"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{}, value)
+
+	r, n, err := db.GetReader([]byte("big"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(big)), n)
+	read, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, big, read)
+
+	dst := make([]byte, len(big))
+	n2, ok, err := db.GetInto([]byte("big"), dst)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, big, dst[:n2])
+
+	iter := db.Iter()
+	for iter.Next() {
+		if string(iter.Key()) == "big" {
+			assert.Equal(t, big, iter.Value())
+		}
+	}
+	require.NoError(t, iter.Err())
+}
+
+func TestUnknownCodec(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Codec: unregisteredCodec{}})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	_, err = Open(f.Name())
+	assert.True(t, errors.Is(err, ErrUnknownCodec), "got %v", err)
+}
+
+type unregisteredCodec struct{ reverseCodec }
+
+func (unregisteredCodec) ID() uint32 { return 1001 }
+
+func TestRegisterCodecTwice(t *testing.T) {
+	assert.Panics(t, func() { RegisterCodec(GzipCodec{}) })
+}
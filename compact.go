@@ -0,0 +1,208 @@
+package cdb64
+
+import (
+	"io"
+	"sort"
+)
+
+// Compact writes a copy of src to dst without any of its dead space, and
+// opens the copy for reads. Records that can't be found, because no hash
+// table points to them, are left out, as are any stale bytes left over from
+// appending. Every record that can still be found is copied, in the same
+// order, so lookups return exactly what they did before; that includes every
+// record for a key written more than once, since GetAll and GetLast can
+// still find them. The hash tables are rebuilt to fit the records that are
+// left.
+//
+// The copy is written with the same hash function, and the same options as
+// src, such as its ValueCodec and checksums. As with Writer.Freeze, dst must
+// also be an io.ReaderAt for the copy to be opened; otherwise Compact returns
+// os.ErrInvalid once it's written.
+func Compact(src *CDB, dst io.WriteSeeker) (*CDB, error) {
+	src.mu.RLock()
+	opts := src.writerOptions()
+	src.mu.RUnlock()
+
+	writer, err := NewWriterWithOptions(dst, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.CopyFrom(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return writer.Freeze()
+}
+
+// CopyFrom writes every record that can be found in src, in the order they're
+// stored, as Compact does. Where it can, it avoids the work Put would do:
+//
+//   - If the Writer encodes values the same way as src, with the same
+//     ValueCodec, RecordChecksums and Expiry options, values are copied as
+//     they're stored, without being decoded and encoded again. Their record
+//     checksums aren't checked along the way; use Verify for that.
+//   - If the Writer hashes keys the same way as src, the hashes stored in
+//     src's hash tables are reused rather than hashing every key again. That's
+//     decided by checking that the first key copied hashes the same.
+//
+// Otherwise, values are decoded and encoded again, so records can be copied
+// between any two databases.
+func (cdb *Writer) CopyFrom(src *CDB) error {
+	if cdb.finalized {
+		return ErrClosed
+	}
+
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+
+	// Only records with a slot in the hash tables can be found.
+	live := make(map[uint64]uint64)
+	for _, table := range src.header {
+		for slot := uint64(0); slot < table.length; slot++ {
+			hash, offset, err := readTuple(src.reader, table.offset+src.slotSize*slot)
+			if err != nil {
+				return err
+			}
+
+			if offset != 0 {
+				live[offset] = hash
+			}
+		}
+	}
+
+	const transforms = flagCodec | flagRecordChecksum | flagExpiry
+	raw := src.flags&transforms == cdb.preamble().flags&transforms &&
+		(src.codec == nil || src.codec.ID() == cdb.options.Codec.ID())
+
+	sameHash := false
+	checkedHash := false
+	pos := src.dataStart
+	for pos < src.header[0].offset {
+		key, stored, err := src.readRecord(pos)
+		if err != nil {
+			return err
+		}
+
+		storedHash, ok := live[pos]
+		pos += 16 + uint64(len(key)+len(stored))
+		if !ok {
+			continue
+		}
+
+		if !checkedHash {
+			sameHash = cdb.hashKey(key) == storedHash
+			checkedHash = true
+		}
+
+		hash := storedHash
+		if !sameHash {
+			hash = cdb.hashKey(key)
+		}
+
+		var recordChecksum []byte
+		if !raw {
+			value, expires, err := src.decodeRecord(key, stored)
+			if err != nil {
+				return err
+			}
+
+			stored, recordChecksum = cdb.encodeValue(value, expires)
+		}
+
+		err = cdb.writeRecord(key, hash, stored, recordChecksum)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropDead moves the records down over the dead ones, removed with Delete or
+// left by a failed PutStream, and points the entries at their new offsets, so
+// that the data section only holds records that can be found.
+func (cdb *Writer) dropDead() error {
+	err := cdb.bufferedWriter.Flush()
+	if err != nil {
+		return err
+	}
+
+	dead := cdb.dead
+	sort.Slice(dead, func(a, b int) bool {
+		return dead[a].offset < dead[b].offset
+	})
+
+	// Records only ever move towards the start of the file, so each one is
+	// read before anything is written over it.
+	r := cdb.writer.(io.ReaderAt)
+	start, end := dead[0].offset, cdb.bufferedOffset
+	_, err = cdb.writer.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// Starting over resets the checksum, which still has to cover the records
+	// before the first dead one.
+	cdb.startData(start)
+	if cdb.checksum != nil {
+		data := int64(cdb.dataStart())
+		_, err = io.Copy(cdb.checksum, io.NewSectionReader(r, data, start-data))
+		if err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 32*1024)
+	shifts := make([]int64, len(dead)+1)
+	for i, record := range dead {
+		next := end
+		if i+1 < len(dead) {
+			next = dead[i+1].offset
+		}
+
+		live := record.offset + record.size
+		n, err := io.CopyBuffer(cdb.dataWriter, io.NewSectionReader(r, live, next-live), buf)
+		if err != nil {
+			return err
+		}
+
+		cdb.bufferedOffset += n
+		shifts[i+1] = shifts[i] + record.size
+	}
+
+	// Every record moves down by the size of the dead ones before it.
+	move := func(entries []entry) {
+		for i := range entries {
+			before := sort.Search(len(dead), func(j int) bool {
+				return uint64(dead[j].offset) > entries[i].offset
+			})
+
+			entries[i].offset -= uint64(shifts[before])
+		}
+	}
+
+	for _, tableEntries := range cdb.entries {
+		move(tableEntries)
+	}
+
+	if cdb.spill != nil {
+		for _, run := range cdb.spill.runs {
+			for _, segment := range run {
+				entries, err := cdb.spill.read(segment)
+				if err != nil {
+					return err
+				}
+
+				move(entries)
+				err = cdb.spill.write(segment.offset, entries)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
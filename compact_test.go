@@ -0,0 +1,106 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Checksum: true, RecordChecksums: true})
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("value "+strconv.Itoa(i))))
+	}
+
+	// Drop the even keys from the hash tables only, which leaves their
+	// records in the file as dead space, unlike Delete.
+	for i := 0; i < 100; i += 2 {
+		hash := writer.hashKey([]byte(strconv.Itoa(i)))
+		_, err = writer.filterEntries(tableFor(hash, writer.tableBits), func(entry entry) (bool, error) {
+			return entry.hash == hash, nil
+		})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Put([]byte("1"), []byte("again")))
+	src, err := writer.Freeze()
+	require.NoError(t, err)
+	defer src.Close()
+
+	out, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(out.Name())
+
+	db, err := Compact(src, out)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Less(t, db.Size(), src.Size())
+	require.NoError(t, db.Verify())
+	require.NoError(t, db.VerifyChecksum())
+	assert.Equal(t, src.Count(), db.Count())
+
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		expected, err := src.GetAll(key)
+		require.NoError(t, err)
+
+		values, err := db.GetAll(key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, values)
+	}
+
+	values, err := db.GetAll([]byte("1"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("value 1"), []byte("again")}, values)
+}
+
+func TestCopyFrom(t *testing.T) {
+	var buf Buffer
+	writer, err := NewWriterWithOptions(&buf, WriterOptions{Codec: GzipCodec{}, RecordChecksums: true})
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("value "+strconv.Itoa(i))))
+	}
+
+	require.NoError(t, writer.Close())
+	src, err := FromBytes(buf.Bytes(), nil)
+	require.NoError(t, err)
+
+	for name, opts := range map[string]WriterOptions{
+		"raw":      {Codec: GzipCodec{}, RecordChecksums: true},
+		"rehash":   {Codec: GzipCodec{}, RecordChecksums: true, Hasher: NewXXHash},
+		"decode":   {},
+		"reencode": {Expiry: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var out Buffer
+			writer, err := NewWriterWithOptions(&out, opts)
+			require.NoError(t, err)
+			require.NoError(t, writer.CopyFrom(src))
+			require.NoError(t, writer.Close())
+
+			db, err := NewWithOptions(&out, ReaderOptions{Hasher: opts.Hasher})
+			require.NoError(t, err)
+			require.NoError(t, db.Verify())
+			assert.Equal(t, 100, db.Count())
+
+			for i := 0; i < 100; i++ {
+				value, err := db.Get([]byte(strconv.Itoa(i)))
+				require.NoError(t, err)
+				assert.Equal(t, "value "+strconv.Itoa(i), string(value))
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package cdb64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const headerSize32 = 256 * 4 * 2
+
+// CDB32 is a read-only view of a classic cdb database, with 32-bit offsets,
+// as written by djb's cdbmake or by github.com/colinmarc/cdb. It exists to
+// ease migrating off those files; there's no writer for the 32-bit format, and
+// new databases should use the 64-bit format with Writer. Convert32 rewrites a
+// 32-bit database in the 64-bit format.
+//
+// Like CDB, a CDB32 is safe for concurrent use by multiple goroutines.
+type CDB32 struct {
+	reader io.ReaderAt
+	header Header
+}
+
+// NewCompat32 opens a classic 32-bit cdb database for the given io.ReaderAt.
+// Lookups use the standard 32-bit cdb hash, as every 32-bit cdb tool does.
+func NewCompat32(reader io.ReaderAt) (*CDB32, error) {
+	buf := make([]byte, headerSize32)
+	_, err := reader.ReadAt(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cdb := &CDB32{reader: reader}
+	for i := 0; i < 256; i++ {
+		off := i * 8
+		cdb.header[i] = table{
+			offset: uint64(binary.LittleEndian.Uint32(buf[off : off+4])),
+			length: uint64(binary.LittleEndian.Uint32(buf[off+4 : off+8])),
+		}
+
+		if cdb.header[i].offset < headerSize32 {
+			return nil, ErrBadMagic
+		}
+	}
+
+	return cdb, nil
+}
+
+// Get returns the value for a given key, or nil if it can't be found.
+func (cdb *CDB32) Get(key []byte) ([]byte, error) {
+	hash := hash32(key)
+	table := cdb.header[hash&0xff]
+	if table.length == 0 {
+		return nil, nil
+	}
+
+	// Probe the hash table exactly as CDB does, but with 8-byte slots. An
+	// empty slot has a zero offset, since no record can start at zero.
+	startingSlot := uint64(hash>>8) % table.length
+	slot := startingSlot
+
+	for {
+		slotHash, offset, err := readTuple32(cdb.reader, table.offset+8*slot)
+		if err != nil {
+			return nil, err
+		}
+
+		if offset == 0 {
+			break
+		} else if slotHash == uint64(hash) {
+			value, err := cdb.getValueAt(offset, key)
+			if err != nil || value != nil {
+				return value, err
+			}
+		}
+
+		slot = (slot + 1) % table.length
+		if slot == startingSlot {
+			break
+		}
+	}
+
+	return nil, nil
+}
+
+// Iter creates an Iterator32 that can be used to iterate the database.
+func (cdb *CDB32) Iter() *Iterator32 {
+	return &Iterator32{
+		db:     cdb,
+		pos:    headerSize32,
+		endPos: cdb.header[0].offset,
+	}
+}
+
+// Close closes the database to further reads.
+func (cdb *CDB32) Close() error {
+	if closer, ok := cdb.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// getValueAt returns the value of the record at offset, or nil if its key
+// isn't expectedKey.
+func (cdb *CDB32) getValueAt(offset uint64, expectedKey []byte) ([]byte, error) {
+	keyLength, valueLength, err := readTuple32(cdb.reader, offset)
+	if err != nil || int(keyLength) != len(expectedKey) {
+		return nil, err
+	}
+
+	buf := make([]byte, keyLength+valueLength)
+	_, err = cdb.reader.ReadAt(buf, int64(offset+8))
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(buf[:keyLength], expectedKey) {
+		return nil, nil
+	}
+
+	return buf[keyLength:], nil
+}
+
+// Iterator32 is a sequential iterator over a CDB32 database.
+type Iterator32 struct {
+	db     *CDB32
+	pos    uint64
+	endPos uint64
+	err    error
+	key    []byte
+	value  []byte
+}
+
+// Next reads the next key/value pair and advances the iterator one record.
+// It returns false when the scan stops, either by reaching the end of the
+// database or an error. After Next returns false, the Err method will return
+// any error that occurred while iterating.
+func (iter *Iterator32) Next() bool {
+	if iter.err != nil || iter.pos >= iter.endPos {
+		return false
+	}
+
+	keyLength, valueLength, err := readTuple32(iter.db.reader, iter.pos)
+	if err != nil {
+		iter.err = err
+		return false
+	}
+
+	buf := make([]byte, keyLength+valueLength)
+	_, err = iter.db.reader.ReadAt(buf, int64(iter.pos+8))
+	if err != nil {
+		iter.err = err
+		return false
+	}
+
+	iter.key = buf[:keyLength]
+	iter.value = buf[keyLength:]
+	iter.pos += 8 + keyLength + valueLength
+
+	return true
+}
+
+// Key returns the current key.
+func (iter *Iterator32) Key() []byte {
+	return iter.key
+}
+
+// Value returns the current value.
+func (iter *Iterator32) Value() []byte {
+	return iter.value
+}
+
+// Err returns the current error.
+func (iter *Iterator32) Err() error {
+	return iter.err
+}
+
+// hash32 is the standard 32-bit cdb hash. It's the low half of the default
+// 64-bit hash, which uses the same steps.
+func hash32(key []byte) uint32 {
+	h := uint32(start)
+	for _, b := range key {
+		h = ((h << 5) + h) ^ uint32(b)
+	}
+
+	return h
+}
+
+func readTuple32(r io.ReaderAt, offset uint64) (uint64, uint64, error) {
+	tuple, err := readAt(r, offset, 8, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	first := binary.LittleEndian.Uint32(tuple[:4])
+	second := binary.LittleEndian.Uint32(tuple[4:])
+	return uint64(first), uint64(second), nil
+}
+
+// Convert32 reads a classic 32-bit cdb database from src, and writes the same
+// records to dst in the 64-bit format, then opens the result for reads. The
+// records keep their order, including any duplicate keys, and the new
+// database uses the default cdb hash, so lookups behave exactly as they did.
+//
+// As with Writer.Freeze, dst must also be an io.ReaderAt for the result to be
+// opened; otherwise, the conversion still completes, but Convert32 returns
+// os.ErrInvalid.
+func Convert32(src io.ReaderAt, dst io.WriteSeeker) (*CDB, error) {
+	db, err := NewCompat32(src)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := NewWriter(dst, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := db.Iter()
+	for iter.Next() {
+		err = writer.Put(iter.Key(), iter.Value())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return writer.Freeze()
+}
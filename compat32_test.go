@@ -0,0 +1,81 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// test/test32.cdb holds the same records as test/test.cdb, in the classic
+// 32-bit format written by djb's cdbmake.
+func openTest32(t *testing.T) *CDB32 {
+	f, err := os.Open("./test/test32.cdb")
+	require.NoError(t, err)
+
+	db, err := NewCompat32(f)
+	require.NoError(t, err)
+	return db
+}
+
+func TestCompat32Get(t *testing.T) {
+	db := openTest32(t)
+	defer db.Close()
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+
+		value, err := db.Get(record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, string(record[1]), string(value), msg)
+	}
+}
+
+func TestCompat32Iterator(t *testing.T) {
+	db := openTest32(t)
+	defer db.Close()
+
+	n := 0
+	iter := db.Iter()
+	for iter.Next() {
+		assert.Equal(t, string(expectedRecords[n][0]), string(iter.Key()))
+		assert.Equal(t, string(expectedRecords[n][1]), string(iter.Value()))
+		n++
+	}
+
+	require.NoError(t, iter.Err())
+	assert.Equal(t, len(expectedRecords)-1, n)
+}
+
+func TestHash32(t *testing.T) {
+	for _, record := range expectedRecords {
+		h := newCDBHash()
+		h.Write(record[0])
+		assert.Equal(t, uint32(h.Sum64()), hash32(record[0]))
+	}
+}
+
+func TestConvert32(t *testing.T) {
+	src, err := os.Open("./test/test32.cdb")
+	require.NoError(t, err)
+	defer src.Close()
+
+	dst, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(dst.Name())
+
+	db, err := Convert32(src, dst)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Verify())
+
+	// The converted file is identical to one written directly.
+	converted, err := ioutil.ReadFile(dst.Name())
+	require.NoError(t, err)
+	expected, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+	assert.Equal(t, expected, converted)
+}
@@ -0,0 +1,337 @@
+package cdb64
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Block-compressed files hold a whole database, compressed in fixed-size
+// blocks so that a reader only has to decompress the block holding the bytes
+// it needs:
+//
+//	magic     [8]byte
+//	blockSize uint32
+//	reserved  uint32, zero
+//	size      uint64, of the uncompressed database
+//	blocks    each one compressed separately with DEFLATE
+//	index     uint64 offset of each block, then of the end of the last one
+//
+// Every block but the last holds blockSize bytes of the database. The number
+// of blocks follows from size and blockSize, so the index is the last
+// 8 * (blocks + 1) bytes of the file.
+const (
+	compressedHeaderSize = 24
+
+	// DefaultCompressBlockSize is the block size used by CreateCompressed
+	// and WriteCompressed if none is given.
+	DefaultCompressBlockSize = 64 * 1024
+
+	// compressedCacheBlocks is the number of decompressed blocks
+	// OpenCompressed keeps in memory.
+	compressedCacheBlocks = 16
+)
+
+// compressedMagic identifies a block-compressed file. It differs from magic
+// in its sixth byte, so a compressed file is never mistaken for a database.
+var compressedMagic = [8]byte{'c', 'd', 'b', '6', '4', 'z', '\n', 0x1a}
+
+// ErrNotCompressed is returned by NewCompressedReaderAt and OpenCompressed
+// for files that aren't block-compressed.
+var ErrNotCompressed = errors.New("not a block-compressed cdb64 database")
+
+// OpenCompressed opens a block-compressed database at the given path, as
+// written by CreateCompressed or WriteCompressed. Lookups decompress only the
+// blocks they read from, and the most recently used blocks are kept in
+// memory, so reads near each other, such as a probe of a hash table followed
+// by the record it points to, don't decompress anything twice. It trades CPU
+// time on every lookup for a much smaller file, which suits large databases
+// that are rarely read.
+func OpenCompressed(path string) (*CDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r, err := NewCompressedReaderAt(f, info.Size(), compressedCacheBlocks)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	db, err := New(r, nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// CreateCompressed is like CreateWithOptions, but writes a block-compressed
+// database, for OpenCompressed, in blocks of blockSize bytes; if blockSize
+// isn't positive, it defaults to DefaultCompressBlockSize. The database is
+// built uncompressed in a temporary file, like with NewStreamWriter, and
+// compressed into path when it's closed. Smaller blocks make lookups cheaper,
+// and larger ones compress better. It can't be combined with HeaderLast.
+func CreateCompressed(path string, blockSize int, opts WriterOptions) (*Writer, error) {
+	if opts.HeaderLast {
+		return nil, errors.New("CreateCompressed can't be combined with HeaderLast")
+	}
+
+	if blockSize <= 0 {
+		blockSize = DefaultCompressBlockSize
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cdb, err := NewStreamWriterWithOptions(f, opts)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	cdb.compressBlockSize = blockSize
+	return cdb, nil
+}
+
+// WriteCompressed writes out the whole database to w block-compressed, in
+// blocks of blockSize bytes, for OpenCompressed. If blockSize isn't positive,
+// it defaults to DefaultCompressBlockSize. It's the compressed counterpart of
+// WriteTo, for converting an existing database.
+func (cdb *CDB) WriteCompressed(w io.Writer, blockSize int) error {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	if blockSize <= 0 {
+		blockSize = DefaultCompressBlockSize
+	}
+
+	return writeCompressed(w, io.NewSectionReader(cdb.reader, 0, int64(cdb.end)), int64(cdb.end), blockSize)
+}
+
+// writeCompressed reads size bytes from r, and writes them to w as a
+// block-compressed file.
+func writeCompressed(w io.Writer, r io.Reader, size int64, blockSize int) error {
+	header := make([]byte, compressedHeaderSize)
+	copy(header, compressedMagic[:])
+	binary.LittleEndian.PutUint32(header[8:], uint32(blockSize))
+	binary.LittleEndian.PutUint64(header[16:], uint64(size))
+	_, err := w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+
+	block := make([]byte, blockSize)
+	offsets := []uint64{compressedHeaderSize}
+	for remaining := size; remaining > 0; remaining -= int64(len(block)) {
+		if remaining < int64(len(block)) {
+			block = block[:remaining]
+		}
+
+		_, err = io.ReadFull(r, block)
+		if err != nil {
+			return err
+		}
+
+		compressed.Reset()
+		fw.Reset(&compressed)
+		_, err = fw.Write(block)
+		if err == nil {
+			err = fw.Close()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(compressed.Bytes())
+		if err != nil {
+			return err
+		}
+
+		offsets = append(offsets, offsets[len(offsets)-1]+uint64(compressed.Len()))
+	}
+
+	index := make([]byte, 8*len(offsets))
+	for i, offset := range offsets {
+		binary.LittleEndian.PutUint64(index[8*i:], offset)
+	}
+
+	_, err = w.Write(index)
+	return err
+}
+
+// CompressedReaderAt is an io.ReaderAt over the uncompressed contents of a
+// block-compressed file, which decompresses blocks as they're read. Pass it
+// to New to read the database; OpenCompressed does that for a file on disk.
+// It's safe for concurrent use.
+type CompressedReaderAt struct {
+	reader    io.ReaderAt
+	blockSize int64
+	size      int64
+	offsets   []uint64
+	cache     *CachedReaderAt
+}
+
+// NewCompressedReaderAt returns a CompressedReaderAt for the block-compressed
+// file of the given size in r, which keeps up to the given number of the most
+// recently used blocks in memory, decompressed. It returns an error wrapping
+// ErrNotCompressed if r isn't a block-compressed file, or ErrCorrupt if its
+// block index is damaged.
+func NewCompressedReaderAt(r io.ReaderAt, size int64, blocks int) (*CompressedReaderAt, error) {
+	if size < compressedHeaderSize {
+		return nil, ErrNotCompressed
+	}
+
+	header := make([]byte, compressedHeaderSize)
+	_, err := r.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	} else if !bytes.Equal(header[:8], compressedMagic[:]) {
+		return nil, ErrNotCompressed
+	}
+
+	blockSize := uint64(binary.LittleEndian.Uint32(header[8:]))
+	dataSize := binary.LittleEndian.Uint64(header[16:])
+	if blockSize == 0 {
+		return nil, fmt.Errorf("%w: zero block size", ErrCorrupt)
+	}
+
+	// Each block takes at least one byte compressed, and 8 in the index.
+	count := dataSize / blockSize
+	if dataSize%blockSize != 0 {
+		count++
+	}
+
+	indexSize := 8 * (count + 1)
+	if count > uint64(size) || indexSize > uint64(size)-compressedHeaderSize {
+		return nil, fmt.Errorf("%w: block index runs past the end of the file", ErrCorrupt)
+	}
+
+	index := make([]byte, indexSize)
+	indexStart := uint64(size) - indexSize
+	_, err = r.ReadAt(index, int64(indexStart))
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]uint64, count+1)
+	previous := uint64(compressedHeaderSize)
+	for i := range offsets {
+		offsets[i] = binary.LittleEndian.Uint64(index[8*i:])
+		if offsets[i] < previous || offsets[i] > indexStart {
+			return nil, fmt.Errorf("%w: block %d is out of bounds", ErrCorrupt, i)
+		}
+
+		previous = offsets[i]
+	}
+
+	c := &CompressedReaderAt{
+		reader:    r,
+		blockSize: int64(blockSize),
+		size:      int64(dataSize),
+		offsets:   offsets,
+	}
+
+	// Each page of the cache is one block.
+	c.cache = NewCachedReaderAt(blockReader{c}, int(blockSize), blocks)
+	return c, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (c *CompressedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("cdb64.CompressedReaderAt.ReadAt: negative offset")
+	} else if off >= c.size {
+		return 0, io.EOF
+	}
+
+	return c.cache.ReadAt(p, off)
+}
+
+// Size returns the size of the uncompressed contents.
+func (c *CompressedReaderAt) Size() int64 {
+	return c.size
+}
+
+// Close closes the underlying reader, if it's an io.Closer, and drops the
+// decompressed blocks.
+func (c *CompressedReaderAt) Close() error {
+	return c.cache.Close()
+}
+
+// blockReader reads whole blocks of a CompressedReaderAt, for its cache,
+// which only ever reads a block at a time.
+type blockReader struct {
+	c *CompressedReaderAt
+}
+
+// flateReaders holds decompressors for blockReader, which otherwise allocates
+// a sizeable one for every block it reads.
+var flateReaders sync.Pool
+
+func (r blockReader) ReadAt(p []byte, off int64) (int, error) {
+	c := r.c
+	block := off / c.blockSize
+	if block >= int64(len(c.offsets)-1) {
+		return 0, io.EOF
+	}
+
+	length := c.size - block*c.blockSize
+	if length > c.blockSize {
+		length = c.blockSize
+	}
+
+	start, end := c.offsets[block], c.offsets[block+1]
+	section := io.NewSectionReader(c.reader, int64(start), int64(end-start))
+	fr, _ := flateReaders.Get().(io.ReadCloser)
+	if fr == nil {
+		fr = flate.NewReader(section)
+	} else {
+		fr.(flate.Resetter).Reset(section, nil)
+	}
+
+	defer flateReaders.Put(fr)
+
+	n, err := io.ReadFull(fr, p[:length])
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return n, fmt.Errorf("%w: block %d is short", ErrCorrupt, block)
+	} else if err != nil {
+		return n, err
+	} else if length < c.blockSize {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Close closes the underlying reader, if it's an io.Closer.
+func (r blockReader) Close() error {
+	if closer, ok := r.c.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package cdb64
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressed(t *testing.T) {
+	f, err := ioutil.TempFile("", "cdb64-compressed")
+	require.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	writer, err := CreateCompressed(f.Name(), 1024, WriterOptions{Checksum: true})
+	require.NoError(t, err)
+
+	value := bytes.Repeat([]byte("compressible "), 20)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, writer.Put([]byte(fmt.Sprintf("key%d", i)), value))
+	}
+
+	require.NoError(t, writer.Close())
+
+	db, err := OpenCompressed(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	info, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	assert.Less(t, info.Size(), db.Size()/4)
+
+	for i := 0; i < 1000; i++ {
+		v, err := db.Get([]byte(fmt.Sprintf("key%d", i)))
+		require.NoError(t, err)
+		assert.Equal(t, value, v)
+	}
+
+	v, err := db.Get([]byte("missing"))
+	require.NoError(t, err)
+	assert.Nil(t, v)
+	require.NoError(t, db.Verify())
+
+	// Compressing an existing database gives the same contents.
+	var compressed, plain bytes.Buffer
+	require.NoError(t, db.WriteCompressed(&compressed, 0))
+	r, err := NewCompressedReaderAt(bytes.NewReader(compressed.Bytes()), int64(compressed.Len()), 1)
+	require.NoError(t, err)
+	_, err = db.WriteTo(&plain)
+	require.NoError(t, err)
+
+	contents := make([]byte, plain.Len())
+	_, err = r.ReadAt(contents, 0)
+	require.NoError(t, err)
+	assert.Equal(t, plain.Bytes(), contents)
+
+	// An uncompressed database isn't mistaken for a compressed one.
+	_, err = NewCompressedReaderAt(bytes.NewReader(plain.Bytes()), int64(plain.Len()), 1)
+	assert.Equal(t, ErrNotCompressed, err)
+}
@@ -0,0 +1,91 @@
+package cdb64
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"strconv"
+)
+
+// Dump writes every record in the database to w, in the text format used by
+// djb's cdbdump and cdbmake:
+//
+//	+klen,dlen:key->data
+//
+// with one line per record, in file order, followed by a blank line. Keys and
+// values are written as-is; the format is length-prefixed, so nothing needs
+// escaping.
+func (cdb *CDB) Dump(w io.Writer) error {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	iter := cdb.iterLocked()
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+
+		bw.WriteByte('+')
+		bw.WriteString(strconv.Itoa(len(key)))
+		bw.WriteByte(',')
+		bw.WriteString(strconv.Itoa(len(value)))
+		bw.WriteByte(':')
+		bw.Write(key)
+		bw.WriteString("->")
+		bw.Write(value)
+		bw.WriteByte('\n')
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
+
+// DumpJSON writes every record in the database to w as a JSON array, in file
+// order, with one object per record:
+//
+//	{"key":"<base64>","value":"<base64>"}
+//
+// Keys and values are base64 encoded, since they're arbitrary bytes. Records
+// are written out as they're read, so it works on databases of any size.
+func (cdb *CDB) DumpJSON(w io.Writer) error {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+
+	first := true
+	iter := cdb.iterLocked()
+	for iter.Next() {
+		if !first {
+			bw.WriteByte(',')
+		}
+
+		first = false
+		bw.WriteString("\n{\"key\":\"")
+		writeBase64(bw, iter.Key())
+		bw.WriteString("\",\"value\":\"")
+		writeBase64(bw, iter.Value())
+		bw.WriteString("\"}")
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	if !first {
+		bw.WriteByte('\n')
+	}
+
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
+
+func writeBase64(w io.Writer, data []byte) {
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	enc.Write(data)
+	enc.Close()
+}
@@ -0,0 +1,67 @@
+package cdb64
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const expectedDump = "+3,3:foo->bar\n" +
+	"+3,6:baz->quuuux\n" +
+	"+10,3:playwright->wow\n" +
+	"+7,7:crystal->CASTLES\n" +
+	"+7,7:CRYSTAL->castles\n" +
+	"+5,10:snush->collision!\n" +
+	"+1,1:a->a\n" +
+	"+11,0:empty_value->\n" +
+	"+0,9:->empty_key\n" +
+	"\n"
+
+func TestDump(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, db.Dump(&buf))
+	assert.Equal(t, expectedDump, buf.String())
+}
+
+func TestDumpJSON(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, db.DumpJSON(&buf))
+
+	var records []struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	}
+
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &records))
+	require.Len(t, records, len(expectedRecords)-1)
+	for i, record := range records {
+		assert.Equal(t, string(expectedRecords[i][0]), string(record.Key))
+		assert.Equal(t, string(expectedRecords[i][1]), string(record.Value))
+	}
+
+	// An empty database is still valid JSON.
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+	db, err = writer.Freeze()
+	require.NoError(t, err)
+	defer db.Close()
+
+	buf.Reset()
+	require.NoError(t, db.DumpJSON(&buf))
+	assert.Equal(t, "[]\n", buf.String())
+}
@@ -0,0 +1,66 @@
+package cdb64
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PutWithExpiry is like Put, but the record expires at the given time, after
+// which CDB.GetFresh treats it as missing. A zero time never expires. The
+// Writer must have been created with WriterOptions.Expiry.
+func (cdb *Writer) PutWithExpiry(key, value []byte, expires time.Time) error {
+	if !cdb.options.Expiry {
+		return errors.New("PutWithExpiry requires WriterOptions.Expiry")
+	}
+
+	var unix int64
+	if !expires.IsZero() {
+		unix = expires.Unix()
+	}
+
+	return cdb.put(key, value, unix)
+}
+
+// GetFresh is like GetOK, but if the record for key has expired by now, it's
+// reported as missing. Only databases written with WriterOptions.Expiry store
+// expiry times; in any other database, records never expire.
+func (cdb *CDB) GetFresh(key []byte, now time.Time) ([]byte, bool, error) {
+	var value []byte
+	var expires int64
+	err := cdb.probe(key, func(offset uint64) (bool, error) {
+		v, e, err := cdb.getRecordAt(offset, key, false)
+		value, expires = v, e
+		return v != nil, err
+	})
+	if err != nil || value == nil {
+		return nil, false, err
+	}
+
+	if expires != 0 && now.Unix() >= expires {
+		return nil, false, nil
+	}
+
+	return value, true, nil
+}
+
+// appendExpiry returns value followed by the expiry time, without modifying
+// value's backing array.
+func appendExpiry(value []byte, expires int64) []byte {
+	buf := make([]byte, len(value)+8)
+	copy(buf, value)
+	binary.LittleEndian.PutUint64(buf[len(value):], uint64(expires))
+
+	return buf
+}
+
+// splitExpiry splits the expiry time off the end of a stored value.
+func splitExpiry(key, data []byte) ([]byte, int64, error) {
+	if len(data) < 8 {
+		return nil, 0, fmt.Errorf("%w: key %q: value is too short for its expiry time", ErrRecordCorrupt, key)
+	}
+
+	expires := int64(binary.LittleEndian.Uint64(data[len(data)-8:]))
+	return data[:len(data)-8], expires, nil
+}
@@ -0,0 +1,74 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFresh(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Expiry: true, RecordChecksums: true})
+	require.NoError(t, err)
+
+	now := time.Unix(1600000000, 0)
+	require.NoError(t, writer.PutWithExpiry([]byte("stale"), []byte("old"), now.Add(-time.Second)))
+	require.NoError(t, writer.PutWithExpiry([]byte("fresh"), []byte("new"), now.Add(time.Hour)))
+	require.NoError(t, writer.PutWithExpiry([]byte("forever"), []byte("always"), time.Time{}))
+	require.NoError(t, writer.Put([]byte("plain"), []byte("value")))
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Verify())
+
+	value, ok, err := db.GetFresh([]byte("stale"), now)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, value)
+
+	// Get ignores expiry times.
+	value, err = db.Get([]byte("stale"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(value))
+
+	for key, expected := range map[string]string{"fresh": "new", "forever": "always", "plain": "value"} {
+		value, ok, err := db.GetFresh([]byte(key), now)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, expected, string(value))
+	}
+
+	_, ok, err = db.GetFresh([]byte("fresh"), now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = db.GetFresh([]byte("missing"), now)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	iter := db.Iter()
+	require.True(t, iter.Next())
+	assert.Equal(t, "stale", string(iter.Key()))
+	assert.Equal(t, "old", string(iter.Value()))
+}
+
+func TestPutWithExpiryRequiresOption(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+	defer writer.Close()
+
+	assert.Error(t, writer.PutWithExpiry([]byte("foo"), []byte("bar"), time.Now()))
+}
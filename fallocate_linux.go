@@ -0,0 +1,22 @@
+//go:build linux
+
+package cdb64
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE, which reserves space without
+// changing the size of the file.
+const fallocKeepSize = 0x1
+
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		// Not every filesystem supports it, and it's only an optimization.
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package cdb64
+
+import "os"
+
+// preallocate isn't supported, so files just grow as they're written.
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,253 @@
+package cdb64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Versioned files start with a small preamble, ahead of the usual table
+// index, that identifies the file as a cdb64 database:
+//
+//	magic   [8]byte
+//	version uint32
+//	flags   uint32
+//	trailer uint64
+//	codec   uint32
+//	tables  uint32
+//	hash    uint32
+//	...     reserved, zero
+//
+// Legacy files have no preamble, and start directly with the table index.
+//
+// If trailer is non-zero, it's the offset of a list of optional sections
+// following the hash tables. Each section is a (id, length) tuple followed by
+// length bytes of payload, and the list ends with a section with id zero.
+const (
+	preambleSize  = 64
+	formatVersion = 1
+)
+
+var (
+	ErrBadMagic           = errors.New("not a cdb64 database")
+	ErrUnsupportedVersion = errors.New("unsupported cdb64 format version")
+
+	// ErrCorruptHeader is returned (wrapped with details) when opening a
+	// database whose header points past the end of the file, which usually
+	// means the file was truncated.
+	ErrCorruptHeader = errors.New("database header points past the end of the file")
+)
+
+// magic can't be confused with the start of a legacy file, which is the
+// little-endian offset of the first hash table; that would have to be over
+// 2^60 bytes for its last byte to be non-zero.
+var magic = [8]byte{'c', 'd', 'b', '6', '4', '\r', '\n', 0x1a}
+
+// Flags stored in the preamble.
+const (
+	// flagChecksum marks files with a CRC32C of the data section, stored in
+	// the trailer.
+	flagChecksum = 1 << iota
+
+	// flagBloom marks files with a bloom filter of key hashes, stored in the
+	// trailer.
+	flagBloom
+
+	// flagCodec marks files whose values are encoded with the ValueCodec
+	// named in the preamble.
+	flagCodec
+
+	// flagSortedKeys marks files with an index of record offsets sorted by
+	// key, stored in the trailer.
+	flagSortedKeys
+
+	// flagRecordChecksum marks files where every record's value is followed
+	// by a CRC32C of it, counted as part of the value's length.
+	flagRecordChecksum
+
+	// flagExpiry marks files where every record's value is followed by its
+	// expiry time, as 8 bytes of unix seconds, or zero if it never expires.
+	// It's counted as part of the value's length, ahead of any checksum.
+	flagExpiry
+
+	// flagHeaderLast marks files written in a single forward pass, where the
+	// table index follows the trailer at the end of the file, instead of
+	// following the preamble. The last 16 bytes of the file are a footer
+	// holding the offsets of the index and of the trailer, which is zero in
+	// the preamble.
+	flagHeaderLast
+
+	// flagHash128 marks files whose hash table slots are 24 bytes rather
+	// than 16: the usual (hash, offset) tuple, followed by a second 64-bit
+	// hash of the key, from checkHash. Together the two hashes make a 128-bit
+	// hash of the key, and readers compare both before reading the key.
+	flagHash128
+)
+
+// knownFlags is the set of flags this version of the package understands.
+const knownFlags = flagChecksum | flagBloom | flagCodec | flagSortedKeys | flagRecordChecksum | flagExpiry | flagHeaderLast | flagHash128
+
+// Section ids in the trailer.
+const (
+	sectionEnd = iota
+	sectionChecksum
+	sectionBloom
+	sectionSortedKeys
+
+	// sectionShard marks one shard of a database written with a
+	// ShardedWriter. It needs no flag, since it doesn't change how the file
+	// is read on its own.
+	sectionShard
+)
+
+// preamble holds the metadata stored at the start of a versioned file.
+type preamble struct {
+	version uint32
+	flags   uint32
+	trailer uint64
+	codec   uint32
+
+	// tables is the number of hash tables, or zero for the default 256.
+	tables uint32
+
+	// hash is the ID of the hash function the file was written with, or
+	// hashUnknown if it isn't recorded.
+	hash uint32
+}
+
+// section is the location of a section payload in the trailer.
+type section struct {
+	offset uint64
+	length uint64
+}
+
+func (p preamble) marshal() []byte {
+	buf := make([]byte, preambleSize)
+	copy(buf, magic[:])
+	binary.LittleEndian.PutUint32(buf[8:12], p.version)
+	binary.LittleEndian.PutUint32(buf[12:16], p.flags)
+	binary.LittleEndian.PutUint64(buf[16:24], p.trailer)
+	binary.LittleEndian.PutUint32(buf[24:28], p.codec)
+	binary.LittleEndian.PutUint32(buf[28:32], p.tables)
+	binary.LittleEndian.PutUint32(buf[32:36], p.hash)
+
+	return buf
+}
+
+func hasMagic(buf []byte) bool {
+	return len(buf) >= len(magic) && bytes.Equal(buf[:len(magic)], magic[:])
+}
+
+func parsePreamble(buf []byte) (preamble, error) {
+	if !hasMagic(buf) {
+		return preamble{}, ErrBadMagic
+	}
+
+	p := preamble{
+		version: binary.LittleEndian.Uint32(buf[8:12]),
+		flags:   binary.LittleEndian.Uint32(buf[12:16]),
+		trailer: binary.LittleEndian.Uint64(buf[16:24]),
+		codec:   binary.LittleEndian.Uint32(buf[24:28]),
+		tables:  binary.LittleEndian.Uint32(buf[28:32]),
+		hash:    binary.LittleEndian.Uint32(buf[32:36]),
+	}
+
+	if p.version != formatVersion || p.flags&^knownFlags != 0 {
+		return p, ErrUnsupportedVersion
+	}
+
+	if p.tables != 0 {
+		if _, err := tableBits(int(p.tables)); err != nil {
+			return p, fmt.Errorf("%w: %s", ErrUnsupportedVersion, err)
+		}
+	}
+
+	return p, nil
+}
+
+// Limits on the number of hash tables. Legacy files always have the default.
+const (
+	defaultTables = 256
+	maxTables     = 1 << 20
+)
+
+// tableBits returns log2 of the number of hash tables, which must be a power
+// of two no larger than maxTables.
+func tableBits(tables int) (uint, error) {
+	if tables <= 0 || tables > maxTables || tables&(tables-1) != 0 {
+		return 0, fmt.Errorf("table count %d isn't a power of two between 1 and %d", tables, maxTables)
+	}
+
+	return uint(bits.TrailingZeros(uint(tables))), nil
+}
+
+// tableFor returns the index of the hash table a hash belongs in, out of
+// 1<<tableBits tables.
+func tableFor(hash uint64, tableBits uint) uint64 {
+	return hash & (1<<tableBits - 1)
+}
+
+// firstSlot returns the slot where probing for a hash starts, in a table
+// with the given number of slots. The bits used to pick the table are
+// skipped, since they're the same for every entry in it.
+func firstSlot(hash uint64, tableBits uint, length uint64) uint64 {
+	return (hash >> tableBits) % length
+}
+
+// readTrailer reads the list of sections starting at offset. It also returns
+// the offset just past the end of the trailer.
+func readTrailer(r io.ReaderAt, offset uint64) (map[uint64]section, uint64, error) {
+	sections := make(map[uint64]section)
+	for {
+		id, length, err := readTuple(r, offset)
+		if err != nil {
+			return nil, 0, err
+		} else if id == sectionEnd {
+			return sections, offset + 16, nil
+		}
+
+		// Sections only move forward, so a bad length can't send the loop
+		// back over sections it's already read.
+		if length > ^uint64(0)-offset-32 {
+			return nil, 0, fmt.Errorf("%w: trailer section %d has a bad length", ErrCorrupt, id)
+		}
+
+		sections[id] = section{offset: offset + 16, length: length}
+		offset += 16 + length
+	}
+}
+
+// writeTrailer writes out the given section payloads, keyed by id, and
+// returns the number of bytes written.
+func writeTrailer(w io.Writer, sections map[uint64][]byte) (int64, error) {
+	var n int64
+	for id := uint64(sectionEnd + 1); len(sections) > 0; id++ {
+		payload, ok := sections[id]
+		if !ok {
+			continue
+		}
+
+		delete(sections, id)
+		err := writeTuple(w, id, uint64(len(payload)))
+		if err != nil {
+			return n, err
+		}
+
+		_, err = w.Write(payload)
+		if err != nil {
+			return n, err
+		}
+
+		n += 16 + int64(len(payload))
+	}
+
+	err := writeTuple(w, sectionEnd, 0)
+	if err != nil {
+		return n, err
+	}
+
+	return n + 16, nil
+}
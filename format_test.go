@@ -0,0 +1,186 @@
+package cdb64
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritesReadableVersioned(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Versioned: true})
+	require.NoError(t, err)
+	require.NotNil(t, writer)
+
+	testWritesReadable(t, writer)
+}
+
+func TestReadsVersioned(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Versioned: true})
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		require.NoError(t, writer.Put(record[0], record[1]))
+	}
+
+	require.NoError(t, writer.Close())
+
+	data, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, magic[:], data[:len(magic)])
+
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, record := range expectedRecords {
+		value, err := db.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, string(record[1]), string(value))
+	}
+
+	n := 0
+	iter := db.Iter()
+	for iter.Next() {
+		assert.Equal(t, string(expectedRecords[n][0]), string(iter.Key()))
+		n++
+	}
+
+	require.NoError(t, iter.Err())
+	assert.Equal(t, len(expectedRecords)-1, n)
+	assert.NoError(t, db.Verify())
+}
+
+func TestRejectsBadMagic(t *testing.T) {
+	data := bytes.Repeat([]byte("not a database! "), 512)
+	_, err := New(bytes.NewReader(data), nil)
+	assert.Equal(t, ErrBadMagic, err)
+}
+
+func TestRejectsUnsupportedVersion(t *testing.T) {
+	p := preamble{version: formatVersion + 1}
+	data := append(p.marshal(), make([]byte, headerSize)...)
+	_, err := New(bytes.NewReader(data), nil)
+	assert.Equal(t, ErrUnsupportedVersion, err)
+
+	p = preamble{version: formatVersion, flags: 1 << 31}
+	data = append(p.marshal(), make([]byte, headerSize)...)
+	_, err = New(bytes.NewReader(data), nil)
+	assert.Equal(t, ErrUnsupportedVersion, err)
+}
+
+func TestHeaderLast(t *testing.T) {
+	// A bytes.Buffer can only be written forwards.
+	var buf bytes.Buffer
+	opts := WriterOptions{HeaderLast: true, Checksum: true, BloomFalsePositiveRate: 0.01}
+	writer, err := NewStreamWriterWithOptions(&buf, opts)
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		require.NoError(t, writer.Put(record[0], record[1]))
+	}
+
+	estimate := writer.EstimatedSize()
+	require.NoError(t, writer.Close())
+	data := buf.Bytes()
+
+	db, err := FromBytes(data, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Verify())
+	require.NoError(t, db.VerifyChecksum())
+	assert.Equal(t, int64(len(data)), db.Size())
+
+	// The estimate leaves out the trailer: the checksum, the bloom filter,
+	// and a tuple for each, plus one to end it.
+	trailer := int64(4 + db.sections[sectionBloom].length + 16*3)
+	assert.Equal(t, int64(len(data)), estimate+trailer)
+
+	for _, record := range expectedRecords {
+		value, err := db.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, string(record[1]), string(value))
+	}
+
+	// The index can only be found from the end of the file.
+	hidden := struct{ io.ReaderAt }{bytes.NewReader(data)}
+	_, err = New(hidden, nil)
+	assert.Error(t, err)
+
+	db, err = NewWithSize(hidden, int64(len(data)), nil)
+	require.NoError(t, err)
+	value, err := db.Get(expectedRecords[0][0])
+	require.NoError(t, err)
+	assert.Equal(t, expectedRecords[0][1], value)
+
+	_, err = FromBytes(data[:len(data)-1], nil)
+	assert.ErrorIs(t, err, ErrCorruptHeader)
+}
+
+func TestHash128(t *testing.T) {
+	// Every key collides in the first hash, so only the second one tells them
+	// apart before the keys are compared.
+	var buf Buffer
+	opts := WriterOptions{Hasher: newConstantHash, Hash128: true, MaxMemory: 24 * 10}
+	writer, err := NewWriterWithOptions(&buf, opts)
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		require.NoError(t, writer.Put(record[0], record[1]))
+	}
+
+	estimate := writer.EstimatedSize()
+	require.NoError(t, writer.Close())
+	assert.Equal(t, int64(len(buf.Bytes())), estimate)
+
+	db, err := NewWithOptions(&buf, ReaderOptions{Hasher: newConstantHash, TrustHash: true})
+	require.NoError(t, err)
+	require.NoError(t, db.Verify())
+
+	for _, record := range expectedRecords {
+		value, err := db.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, string(record[1]), string(value))
+	}
+
+	// Slots are 24 bytes, two per record.
+	stats := db.Stats()
+	assert.Equal(t, int64(len(buf.Bytes())), int64(db.header[0].offset)+24*2*int64(stats.Records))
+}
+
+func TestHash128Append(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Hash128: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	writer, err = OpenForAppend(f.Name())
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("baz"), []byte("quux")))
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Verify())
+	assert.NotZero(t, db.flags&flagHash128)
+	for key, expected := range map[string]string{"foo": "bar", "baz": "quux"} {
+		value, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+}
@@ -0,0 +1,97 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzOpen opens arbitrary bytes as a database, and makes sure that reading
+// from it returns errors rather than panicking.
+func FuzzOpen(f *testing.F) {
+	for _, path := range []string{"./test/test.cdb"} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(data)
+	}
+
+	var buf Buffer
+	writer, err := NewWriterWithOptions(&buf, WriterOptions{
+		TableCount:             4,
+		Checksum:               true,
+		BloomFalsePositiveRate: 0.1,
+		SortedKeys:             true,
+		RecordChecksums:        true,
+		Expiry:                 true,
+		HeaderLast:             true,
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		writer.Put(record[0], record[1])
+	}
+
+	if err := writer.Close(); err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		db, err := FromBytes(data, nil)
+		if err != nil {
+			return
+		}
+
+		for _, record := range expectedRecords {
+			db.Get(record[0])
+			db.GetAll(record[0])
+			db.SizeOf(record[0])
+		}
+
+		iter := db.Iter()
+		for iter.Next() {
+		}
+
+		keys := db.IterKeys()
+		for keys.Next() {
+		}
+
+		prefix := db.ScanPrefix(nil)
+		for prefix.Next() {
+		}
+
+		db.Verify()
+		db.VerifyChecksum()
+		db.AverageProbeLength()
+		db.ValueSizeHistogram([]int64{10, 100})
+	})
+}
+
+// FuzzGet looks up arbitrary keys in a valid database.
+func FuzzGet(f *testing.F) {
+	db, err := Open("./test/test.cdb")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, record := range expectedRecords {
+		f.Add(record[0])
+	}
+
+	f.Fuzz(func(t *testing.T, key []byte) {
+		value, err := db.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, record := range expectedRecords {
+			if string(record[0]) == string(key) && string(record[1]) != string(value) {
+				t.Fatalf("got %q for %q, expected %q", value, key, record[1])
+			}
+		}
+	})
+}
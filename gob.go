@@ -0,0 +1,35 @@
+package cdb64
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// PutGob gob-encodes v, and adds it to the database under key.
+func PutGob[T any](w *Writer, key []byte, v T) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return err
+	}
+
+	return w.Put(key, buf.Bytes())
+}
+
+// GetGob looks up the value for key, and gob-decodes it into a T, as written
+// by PutGob. If the key can't be found, it returns the zero value of T and
+// false.
+func GetGob[T any](cdb *CDB, key []byte) (T, bool, error) {
+	var v T
+	value, err := cdb.Get(key)
+	if err != nil || value == nil {
+		return v, false, err
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(value)).Decode(&v)
+	if err != nil {
+		return v, true, err
+	}
+
+	return v, true, nil
+}
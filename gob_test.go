@@ -0,0 +1,52 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type gobRecord struct {
+	Name  string
+	Tags  []string
+	Count int
+}
+
+func TestGob(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+
+	alice := gobRecord{Name: "Alice", Tags: []string{"practice"}, Count: 3}
+	require.NoError(t, PutGob(writer, []byte("alice"), alice))
+	require.NoError(t, PutGob(writer, []byte("answer"), 42))
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+
+	record, found, err := GetGob[gobRecord](db, []byte("alice"))
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, alice, record)
+
+	answer, found, err := GetGob[int](db, []byte("answer"))
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 42, answer)
+
+	record, found, err = GetGob[gobRecord](db, []byte("bob"))
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, gobRecord{}, record)
+
+	// Decoding into the wrong type is an error, not a miss.
+	_, found, err = GetGob[gobRecord](db, []byte("answer"))
+	assert.Error(t, err)
+	assert.True(t, found)
+}
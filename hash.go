@@ -3,10 +3,104 @@ package cdb64
 import (
 	"encoding/binary"
 	"hash"
+	"hash/fnv"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
 )
 
 const start = 5381
 
+// NewXXHash returns a new xxHash64 hasher. It can be passed as the HashFunc to
+// both NewWriter and New.
+//
+// The default cdb hash clusters badly on some key sets, such as keys that
+// differ only in their last few bytes, which makes for longer probe
+// sequences. xxHash spreads those keys out much more evenly, at the cost of
+// compatibility with other cdb tools.
+func NewXXHash() hash.Hash64 {
+	return xxhash.New()
+}
+
+// NewFNV1a returns a new 64-bit FNV-1a hasher, from hash/fnv. It can be passed
+// as the HashFunc to both NewWriter and New, for compatibility with other
+// tools that hash keys with FNV-1a:
+//
+//	writer, err := cdb64.NewWriter(f, cdb64.NewFNV1a)
+//
+// Since the hash function is recorded in the header, the database can then be
+// opened with a nil HashFunc. fnv.New64a works just as well, and is recorded
+// the same way.
+func NewFNV1a() hash.Hash64 {
+	return fnv.New64a()
+}
+
+// Hash function IDs, recorded in the preamble so that readers can tell which
+// hash function a database was written with. Zero means it isn't recorded,
+// either because the file predates it, or because the hash function isn't one
+// of these.
+const (
+	hashUnknown = iota
+	hashCDB
+	hashXXHash
+	hashFNV1a
+)
+
+// builtinHashes are the hash functions that can be recorded by ID, and their
+// names, for CDB.HashID.
+var builtinHashes = map[uint32]struct {
+	name string
+	fn   HashFunc
+}{
+	hashCDB:    {"cdb", newCDBHash},
+	hashXXHash: {"xxhash", NewXXHash},
+	hashFNV1a:  {"fnv1a", NewFNV1a},
+}
+
+// hashProbe is hashed to recognize the built-in hash functions; see
+// identifyHash.
+var hashProbe = []byte("cdb64 hash probe")
+
+// identifyHash returns the ID of the built-in hash function that fn makes, or
+// hashUnknown if it isn't one. Functions can't be compared, so it goes by what
+// they do: fn is taken to be a built-in hash if it hashes hashProbe the same
+// way.
+func identifyHash(fn HashFunc) uint32 {
+	h := fn()
+	h.Write(hashProbe)
+	sum := h.Sum64()
+	for id, builtin := range builtinHashes {
+		b := builtin.fn()
+		b.Write(hashProbe)
+		if b.Sum64() == sum {
+			return id
+		}
+	}
+
+	return hashUnknown
+}
+
+// checkSeed seeds the xxHash64 that makes the second hash word in databases
+// written with WriterOptions.Hash128. It's fixed, rather than following the
+// database's Hasher, so that the two words are independent even when the
+// Hasher is NewXXHash.
+const checkSeed = 0x9e3779b97f4a7c15
+
+var checkHashers = sync.Pool{
+	New: func() interface{} { return xxhash.NewWithSeed(checkSeed) },
+}
+
+// checkHash returns the second hash word for key.
+func checkHash(key []byte) uint64 {
+	d := checkHashers.Get().(*xxhash.Digest)
+	d.ResetWithSeed(checkSeed)
+	d.Write(key)
+	sum := d.Sum64()
+	checkHashers.Put(d)
+
+	return sum
+}
+
 type cdbHash struct {
 	uint64
 }
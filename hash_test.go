@@ -0,0 +1,150 @@
+package cdb64
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritesReadableXXHash(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, NewXXHash)
+	require.NoError(t, err)
+	require.NotNil(t, writer)
+
+	testWritesReadable(t, writer)
+}
+
+func TestWritesRandomXXHash(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, NewXXHash)
+	require.NoError(t, err)
+	require.NotNil(t, writer)
+
+	testWritesRandom(t, writer)
+}
+
+func benchmarkProbeLength(b *testing.B, hasher HashFunc) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(b, err)
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	writer, err := NewWriter(f, hasher)
+	require.NoError(b, err)
+
+	// Sequential, similar-looking keys are a bad case for the cdb hash.
+	keys := make([][]byte, 100000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("user:%08d", i))
+		require.NoError(b, writer.Put(keys[i], []byte("x")))
+	}
+
+	db, err := writer.Freeze()
+	require.NoError(b, err)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.Get(keys[i%len(keys)])
+	}
+
+	b.StopTimer()
+	probes, err := db.AverageProbeLength()
+	require.NoError(b, err)
+	b.ReportMetric(probes, "slots/lookup")
+}
+
+func BenchmarkProbeLengthCDBHash(b *testing.B) {
+	benchmarkProbeLength(b, nil)
+}
+
+func BenchmarkProbeLengthXXHash(b *testing.B) {
+	benchmarkProbeLength(b, NewXXHash)
+}
+
+func TestWritesReadableFNV1a(t *testing.T) {
+	var buf Buffer
+	writer, err := NewWriter(&buf, NewFNV1a)
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords {
+		if record[1] != nil {
+			require.NoError(t, writer.Put(record[0], record[1]))
+		}
+	}
+
+	require.NoError(t, writer.Close())
+
+	// The header records FNV-1a, so it doesn't need to be passed to New.
+	db, err := New(&buf, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "fnv1a", db.HashID())
+
+	for _, record := range expectedRecords {
+		value, err := db.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, record[1], value)
+	}
+
+	_, err = New(&buf, NewXXHash)
+	assert.True(t, errors.Is(err, ErrHashMismatch), "got %v", err)
+}
+
+func TestHashID(t *testing.T) {
+	var buf Buffer
+	writer, err := NewWriter(&buf, NewXXHash)
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	// The recorded hash function is selected automatically.
+	db, err := New(&buf, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "xxhash", db.HashID())
+
+	value, err := db.Get([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+
+	db, err = New(&buf, NewXXHash)
+	require.NoError(t, err)
+	assert.Equal(t, "xxhash", db.HashID())
+
+	_, err = New(&buf, newCDBHash)
+	assert.True(t, errors.Is(err, ErrHashMismatch), "got %v", err)
+
+	_, err = New(&buf, newConstantHash)
+	assert.True(t, errors.Is(err, ErrHashMismatch), "got %v", err)
+
+	// Versioned databases record the default hash too, but legacy ones and
+	// ones with hash functions from elsewhere have nowhere to.
+	for _, opts := range []WriterOptions{{Versioned: true}, {}, {Hasher: newConstantHash, Versioned: true}} {
+		var buf Buffer
+		writer, err := NewWriterWithOptions(&buf, opts)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		db, err := New(&buf, opts.Hasher)
+		require.NoError(t, err)
+
+		expected := ""
+		if opts.Versioned && opts.Hasher == nil {
+			expected = "cdb"
+		}
+
+		assert.Equal(t, expected, db.HashID())
+	}
+}
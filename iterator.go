@@ -1,54 +1,250 @@
 package cdb64
 
-// Iterator represents a sequential iterator over a CDB database.
+import (
+	"bytes"
+	"errors"
+)
+
+// Iterator represents a sequential iterator over a CDB database. It reads the
+// database as it was when the iterator was created, so it's unaffected by a
+// concurrent Reopen, but like any iterator it mustn't be used once Reopen has
+// returned, since the file it reads from is closed.
 type Iterator struct {
+	// db is a snapshot of the database, so that Next never reads fields
+	// Reopen replaces.
 	db     *CDB
 	pos    uint64
 	endPos uint64
 	err    error
 	key    []byte
 	value  []byte
+
+	// For prefix scans, records are visited in the order of the sorted key
+	// index instead, starting at index, until a key doesn't match prefix.
+	sorted *section
+	index  uint64
+	prefix []byte
+
+	// For IterByTable, records are visited by following the occupied slots
+	// of each hash table in turn.
+	byTable bool
+	table   int
+	slot    uint64
+
+	// For Filter, only records that filter returns true for are visited.
+	filter func(key, value []byte) bool
 }
 
 // Iter creates an Iterator that can be used to iterate the database.
 func (cdb *CDB) Iter() *Iterator {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return cdb.iterLocked()
+}
+
+// iterLocked is like Iter, but expects the caller to hold mu.
+func (cdb *CDB) iterLocked() *Iterator {
 	return &Iterator{
-		db:     cdb,
-		pos:    uint64(headerSize),
+		db:     cdb.snapshot(),
+		pos:    cdb.dataStart,
 		endPos: cdb.header[0].offset,
 	}
 }
 
+// IterByTable creates an Iterator that visits the records in hash table
+// order: table by table, and slot by slot within each table, following every
+// occupied slot to its record. That reads the hash tables sequentially, which
+// makes it a good fit for rebuilding them, but the records themselves are
+// visited in an order unrelated to the one they were written in, unlike with
+// Iter. Every record has exactly one slot, so none is visited twice, and
+// records that no slot points to aren't visited at all.
+func (cdb *CDB) IterByTable() *Iterator {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return &Iterator{db: cdb.snapshot(), byTable: true}
+}
+
+// Filter creates an Iterator that visits the records for which pred returns
+// true, in the order they're stored, as with Iter. pred is called with every
+// record's key and value, which it mustn't modify. Every record is still
+// read, so it's no faster than checking them yourself, but it keeps ad-hoc
+// scans short:
+//
+//	iter := db.Filter(func(key, value []byte) bool {
+//		return len(value) > 1024
+//	})
+func (cdb *CDB) Filter(pred func(key, value []byte) bool) *Iterator {
+	iter := cdb.Iter()
+	iter.filter = pred
+	return iter
+}
+
+// ErrStopIteration can be returned by the function passed to ForEach to stop
+// early without an error.
+var ErrStopIteration = errors.New("stop iteration")
+
+// ForEach calls fn with the key and value of every record, in the order
+// they're stored, as with Iter. If fn returns an error, ForEach stops and
+// returns it, unless it wraps ErrStopIteration, in which case ForEach returns
+// nil.
+//
+// To save allocating for every record, the key and value passed to fn are
+// only valid until it returns, and mustn't be modified; copy them to keep
+// them.
+//
+// It holds the database's read lock throughout, so a concurrent Reopen waits
+// for it to finish, but fn mustn't call Reopen itself.
+func (cdb *CDB) ForEach(fn func(key, value []byte) error) error {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	_, inMemory := cdb.reader.(byteSlicer)
+
+	var buf []byte
+	pos := cdb.dataStart
+	for pos < cdb.header[0].offset {
+		keyLength, valueLength, err := readTuple(cdb.reader, pos)
+		if err != nil {
+			return err
+		}
+
+		end, err := cdb.recordEnd(pos, keyLength, valueLength)
+		if err != nil {
+			return err
+		}
+
+		err = cdb.checkValueSize(pos, valueLength)
+		if err != nil {
+			return err
+		}
+
+		record, err := readAt(cdb.reader, pos+16, keyLength+valueLength, buf)
+		if err != nil {
+			return err
+		}
+
+		if !inMemory {
+			buf = record
+		}
+
+		key := record[:keyLength]
+		value, err := cdb.decode(key, record[keyLength:])
+		if err != nil {
+			return err
+		}
+
+		err = fn(key, value)
+		if errors.Is(err, ErrStopIteration) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		pos = end
+	}
+
+	return nil
+}
+
 // Next reads the next key/value pair and advances the iterator one record.
 // It returns false when the scan stops, either by reaching the end of the
 // database or an error. After Next returns false, the Err method will return
 // any error that occurred while iterating.
 func (iter *Iterator) Next() bool {
-	if iter.pos >= iter.endPos {
+	for iter.next() {
+		if iter.filter == nil || iter.filter(iter.key, iter.value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// next advances the iterator one record, regardless of its filter.
+func (iter *Iterator) next() bool {
+	if iter.err != nil {
 		return false
 	}
 
-	keyLength, valueLength, err := readTuple(iter.db.reader, iter.pos)
+	pos := iter.pos
+	if iter.sorted != nil {
+		if iter.index >= iter.sorted.length/8 {
+			return false
+		}
+
+		offset, err := iter.db.sortedOffsetAt(*iter.sorted, iter.index)
+		if err != nil {
+			iter.err = err
+			return false
+		}
+
+		pos = offset
+	} else if iter.byTable {
+		offset, ok, err := iter.nextSlot()
+		if err != nil {
+			iter.err = err
+			return false
+		} else if !ok {
+			return false
+		}
+
+		pos = offset
+	} else if iter.pos >= iter.endPos {
+		return false
+	}
+
+	key, stored, err := iter.db.readRecord(pos)
 	if err != nil {
 		iter.err = err
 		return false
 	}
 
-	buf := make([]byte, keyLength+valueLength)
-	_, err = iter.db.reader.ReadAt(buf, int64(iter.pos+16))
+	if iter.sorted != nil && !bytes.HasPrefix(key, iter.prefix) {
+		iter.index = iter.sorted.length / 8
+		return false
+	}
+
+	value, err := iter.db.decode(key, stored)
 	if err != nil {
 		iter.err = err
 		return false
 	}
 
 	// Update iterator state
-	iter.key = buf[:keyLength]
-	iter.value = buf[keyLength:]
-	iter.pos += 16 + keyLength + valueLength
+	iter.key = key
+	iter.value = value
+	iter.pos = pos + 16 + uint64(len(key)+len(stored))
+	iter.index++
 
 	return true
 }
 
+// nextSlot advances to the next occupied hash table slot, and returns the
+// offset of its record, or false if there are none left.
+func (iter *Iterator) nextSlot() (uint64, bool, error) {
+	for iter.table < len(iter.db.header) {
+		table := iter.db.header[iter.table]
+		for iter.slot < table.length {
+			_, offset, err := readTuple(iter.db.reader, table.offset+iter.db.slotSize*iter.slot)
+			if err != nil {
+				return 0, false, err
+			}
+
+			iter.slot++
+			if offset != 0 {
+				return offset, true, nil
+			}
+		}
+
+		iter.table++
+		iter.slot = 0
+	}
+
+	return 0, false, nil
+}
+
 // Key returns the current key.
 func (iter *Iterator) Key() []byte {
 	return iter.key
@@ -63,3 +259,183 @@ func (iter *Iterator) Value() []byte {
 func (iter *Iterator) Err() error {
 	return iter.err
 }
+
+// KeyIterator is a sequential iterator over the keys of a database. Unlike
+// Iterator, it never reads the values, skipping over them instead, which makes
+// it much cheaper for databases with large values. Like Iterator, it reads
+// the database as it was when it was created.
+type KeyIterator struct {
+	db     *CDB
+	pos    uint64
+	endPos uint64
+	err    error
+	key    []byte
+	buf    []byte
+}
+
+// IterKeys creates a KeyIterator that can be used to iterate over the keys in
+// the database, in the order they were written.
+func (cdb *CDB) IterKeys() *KeyIterator {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return cdb.iterKeysLocked()
+}
+
+// iterKeysLocked is like IterKeys, but expects the caller to hold mu.
+func (cdb *CDB) iterKeysLocked() *KeyIterator {
+	return &KeyIterator{
+		db:     cdb.snapshot(),
+		pos:    cdb.dataStart,
+		endPos: cdb.header[0].offset,
+	}
+}
+
+// Keys returns every key in the database, in the order they were written, as
+// IterKeys would visit them; a key written more than once appears more than
+// once. It holds all of the keys in memory at once, so for large databases,
+// use IterKeys instead. It holds the database's read lock throughout, so a
+// concurrent Reopen waits for it to finish.
+func (cdb *CDB) Keys() ([][]byte, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	var keys [][]byte
+	iter := cdb.iterKeysLocked()
+	for iter.Next() {
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Next reads the next key and advances the iterator one record. It returns
+// false when the scan stops, either by reaching the end of the database or an
+// error. After Next returns false, the Err method will return any error that
+// occurred while iterating.
+func (iter *KeyIterator) Next() bool {
+	if iter.pos >= iter.endPos {
+		return false
+	}
+
+	keyLength, valueLength, err := readTuple(iter.db.reader, iter.pos)
+	if err != nil {
+		iter.err = err
+		return false
+	}
+
+	end, err := iter.db.recordEnd(iter.pos, keyLength, valueLength)
+	if err != nil {
+		iter.err = err
+		return false
+	}
+
+	key, err := readAt(iter.db.reader, iter.pos+16, keyLength, iter.buf)
+	if err != nil {
+		iter.err = err
+		return false
+	}
+
+	if _, ok := iter.db.reader.(byteSlicer); !ok {
+		iter.buf = key
+	}
+
+	iter.key = key
+	iter.pos = end
+
+	return true
+}
+
+// Key returns the current key. It's only valid until the next call to Next,
+// and must not be modified.
+func (iter *KeyIterator) Key() []byte {
+	return iter.key
+}
+
+// Err returns the current error.
+func (iter *KeyIterator) Err() error {
+	return iter.err
+}
+
+// HashIterator is an iterator over the occupied slots of a database's hash
+// tables. It reads only the hash tables, never the records they point to.
+// Like Iterator, it reads the database as it was when it was created.
+type HashIterator struct {
+	db     *CDB
+	table  int
+	slot   uint64
+	hash   uint64
+	offset uint64
+	err    error
+}
+
+// IterHashes creates a HashIterator over every occupied slot in the database,
+// in table and slot order. Comparing each slot with the slot its hash points
+// to gives the probe distance for that record.
+func (cdb *CDB) IterHashes() *HashIterator {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return &HashIterator{db: cdb.snapshot()}
+}
+
+// Next advances the iterator to the next occupied slot. It returns false when
+// there are no more slots, or an error occurred; after that, the Err method
+// will return any error that occurred while iterating.
+func (iter *HashIterator) Next() bool {
+	if iter.err != nil {
+		return false
+	}
+
+	for iter.table < len(iter.db.header) {
+		table := iter.db.header[iter.table]
+		for iter.slot < table.length {
+			hash, offset, err := readTuple(iter.db.reader, table.offset+iter.db.slotSize*iter.slot)
+			if err != nil {
+				iter.err = err
+				return false
+			}
+
+			iter.slot++
+			if offset != 0 {
+				iter.hash = hash
+				iter.offset = offset
+				return true
+			}
+		}
+
+		iter.table++
+		iter.slot = 0
+	}
+
+	return false
+}
+
+// Hash returns the hash stored in the current slot.
+func (iter *HashIterator) Hash() uint64 {
+	return iter.hash
+}
+
+// Offset returns the offset of the record the current slot points to.
+func (iter *HashIterator) Offset() uint64 {
+	return iter.offset
+}
+
+// Table returns the index of the hash table containing the current slot.
+func (iter *HashIterator) Table() int {
+	return iter.table
+}
+
+// Slot returns the index of the current slot within its hash table.
+func (iter *HashIterator) Slot() uint64 {
+	return iter.slot - 1
+}
+
+// Err returns the current error.
+func (iter *HashIterator) Err() error {
+	return iter.err
+}
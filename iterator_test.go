@@ -1,8 +1,13 @@
 package cdb64
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"os"
+	"strconv"
 	"testing"
 	"time"
 
@@ -29,6 +34,188 @@ func TestIterator(t *testing.T) {
 	require.NoError(t, iter.Err())
 }
 
+func TestFilter(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	var expected [][2]string
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		if len(record[1]) > 5 {
+			expected = append(expected, [2]string{string(record[0]), string(record[1])})
+		}
+	}
+
+	require.NotEmpty(t, expected)
+	var found [][2]string
+	iter := db.Filter(func(key, value []byte) bool { return len(value) > 5 })
+	for iter.Next() {
+		found = append(found, [2]string{string(iter.Key()), string(iter.Value())})
+	}
+
+	require.NoError(t, iter.Err())
+	assert.Equal(t, expected, found)
+}
+
+func TestForEach(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	n := 0
+	err = db.ForEach(func(key, value []byte) error {
+		assert.Equal(t, string(expectedRecords[n][0]), string(key))
+		assert.Equal(t, string(expectedRecords[n][1]), string(value))
+		n++
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, len(expectedRecords)-1, n)
+
+	n = 0
+	err = db.ForEach(func(key, value []byte) error {
+		n++
+		if n == 3 {
+			return ErrStopIteration
+		}
+
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// A wrapped ErrStopIteration stops just the same.
+	n = 0
+	err = db.ForEach(func(key, value []byte) error {
+		n++
+		return fmt.Errorf("done: %w", ErrStopIteration)
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	failed := errors.New("failed")
+	err = db.ForEach(func(key, value []byte) error { return failed })
+	assert.Equal(t, failed, err)
+}
+
+func TestIteratorStopsAtHashTables(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i*i))))
+	}
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+
+	n := 0
+	iter := db.Iter()
+	for iter.Next() {
+		assert.Equal(t, strconv.Itoa(n), string(iter.Key()))
+		assert.Equal(t, strconv.Itoa(n*n), string(iter.Value()))
+		n++
+	}
+
+	require.NoError(t, iter.Err())
+	assert.Equal(t, 100, n)
+	assert.False(t, iter.Next())
+}
+
+func TestIterKeys(t *testing.T) {
+	for _, open := range []func(string) (*CDB, error){Open, OpenMmap} {
+		db, err := open("./test/test.cdb")
+		require.NoError(t, err)
+
+		n := 0
+		iter := db.IterKeys()
+		for iter.Next() {
+			assert.Equal(t, string(expectedRecords[n][0]), string(iter.Key()))
+			n++
+		}
+
+		require.NoError(t, iter.Err())
+		assert.Equal(t, len(expectedRecords)-1, n)
+		assert.False(t, iter.Next())
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestKeys(t *testing.T) {
+	for _, open := range []func(string) (*CDB, error){Open, OpenMmap} {
+		db, err := open("./test/test.cdb")
+		require.NoError(t, err)
+
+		keys, err := db.Keys()
+		require.NoError(t, err)
+		require.Len(t, keys, len(expectedRecords)-1)
+		for i, key := range keys {
+			assert.Equal(t, string(expectedRecords[i][0]), string(key))
+		}
+
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestIterHashes(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	require.NotNil(t, db)
+
+	offsets := make(map[uint64]bool)
+	iter := db.IterHashes()
+	for iter.Next() {
+		assert.Equal(t, iter.Hash()&0xff, uint64(iter.Table()))
+		assert.True(t, iter.Slot() < db.header[iter.Table()].length)
+
+		// The slot should point back to a record with the same hash.
+		keyLength, _, err := readTuple(db.reader, iter.Offset())
+		require.NoError(t, err)
+		key := make([]byte, keyLength)
+		_, err = db.reader.ReadAt(key, int64(iter.Offset()+16))
+		require.NoError(t, err)
+		assert.Equal(t, iter.Hash(), db.hashKey(key))
+
+		offsets[iter.Offset()] = true
+	}
+
+	require.NoError(t, iter.Err())
+	assert.Equal(t, len(expectedRecords)-1, len(offsets))
+}
+
+func TestIterByTable(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	expected := make(map[string]string)
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		expected[string(record[0])] = string(record[1])
+	}
+
+	seen := make(map[string]string)
+	lastTable := uint64(0)
+	iter := db.IterByTable()
+	for iter.Next() {
+		table := tableFor(db.hashKey(iter.Key()), db.tableBits)
+		assert.True(t, table >= lastTable, "tables should be visited in order")
+		lastTable = table
+
+		seen[string(iter.Key())] = string(iter.Value())
+	}
+
+	require.NoError(t, iter.Err())
+	assert.Equal(t, expected, seen)
+	assert.False(t, iter.Next())
+}
+
 func BenchmarkIterator(b *testing.B) {
 	db, _ := Open("./test/test.cdb")
 	iter := db.Iter()
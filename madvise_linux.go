@@ -0,0 +1,19 @@
+//go:build linux
+
+package cdb64
+
+import (
+	"os"
+	"syscall"
+)
+
+// willNeed advises the OS that the mapped file data, from start to end, will
+// be read soon, so that it reads it ahead in the background.
+func willNeed(data []byte, start, end uint64) error {
+	start &^= uint64(os.Getpagesize() - 1)
+	if start >= end || end > uint64(len(data)) {
+		return nil
+	}
+
+	return syscall.Madvise(data[start:end], syscall.MADV_WILLNEED)
+}
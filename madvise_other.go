@@ -0,0 +1,25 @@
+//go:build !linux
+
+package cdb64
+
+import "os"
+
+// willNeedSink keeps the reads in willNeed from being optimized away.
+var willNeedSink byte
+
+// willNeed can't use madvise, so it faults in the mapped file data, from
+// start to end, by reading a byte from every page.
+func willNeed(data []byte, start, end uint64) error {
+	if end > uint64(len(data)) {
+		return nil
+	}
+
+	var sum byte
+	page := uint64(os.Getpagesize())
+	for i := start; i < end; i += page {
+		sum += data[i]
+	}
+
+	willNeedSink = sum
+	return nil
+}
@@ -0,0 +1,188 @@
+package cdb64
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A ParseError is returned by Make when its input is malformed.
+type ParseError struct {
+	// Offset is the byte offset in the input where the problem was found.
+	Offset int64
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("malformed cdbmake input at byte %d: %s", e.Offset, e.Msg)
+}
+
+// Make builds a database from r, which should be in the text format read by
+// djb's cdbmake and written by Dump:
+//
+//	+klen,dlen:key->data
+//
+// with one line per record, followed by a blank line. The database is written
+// to w, and opened for reads once the blank line is reached; anything after it
+// is ignored. If the input is malformed, Make returns a *ParseError.
+//
+// If hasher is nil, it will default to the CDB hash function.
+func Make(w io.WriteSeeker, r io.Reader, hasher HashFunc) (*CDB, error) {
+	writer, err := NewWriter(w, hasher)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &makeParser{r: bufio.NewReader(r)}
+	var key, value bytes.Buffer
+	for {
+		c, err := p.readByte()
+		if err != nil {
+			return nil, err
+		} else if c == '\n' {
+			break
+		} else if c != '+' {
+			return nil, p.errorf(-1, "expected '+' or a blank line, found %q", c)
+		}
+
+		keyLength, err := p.readLength(',')
+		if err != nil {
+			return nil, err
+		}
+
+		valueLength, err := p.readLength(':')
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.readBytes(&key, keyLength)
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect("->")
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.readBytes(&value, valueLength)
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect("\n")
+		if err != nil {
+			return nil, err
+		}
+
+		err = writer.Put(key.Bytes(), value.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return writer.Freeze()
+}
+
+// BuildFromMap builds a database holding every key/value pair in m, writes it
+// to w, and opens it for reads, which makes it handy for setting up fixtures
+// in tests. The records are written in order of their keys, so the same map
+// always builds the same file. As with Freeze, w must also be an io.ReaderAt
+// for the database to be opened; otherwise it returns os.ErrInvalid, after
+// writing it.
+func BuildFromMap(w io.WriteSeeker, m map[string][]byte) (*CDB, error) {
+	writer, err := NewWriter(w, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		err = writer.Put([]byte(key), m[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return writer.Freeze()
+}
+
+// makeParser reads cdbmake input, keeping track of the offset for errors.
+type makeParser struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func (p *makeParser) errorf(delta int64, format string, args ...interface{}) error {
+	return &ParseError{Offset: p.offset + delta, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *makeParser) readByte() (byte, error) {
+	c, err := p.r.ReadByte()
+	if err == io.EOF {
+		return 0, p.errorf(0, "unexpected end of input")
+	} else if err != nil {
+		return 0, err
+	}
+
+	p.offset++
+	return c, nil
+}
+
+// readLength reads a decimal length, terminated by sep.
+func (p *makeParser) readLength(sep byte) (int64, error) {
+	var n int64
+	digits := 0
+	for {
+		c, err := p.readByte()
+		if err != nil {
+			return 0, err
+		}
+
+		if c == sep && digits > 0 {
+			return n, nil
+		} else if c < '0' || c > '9' {
+			return 0, p.errorf(-1, "expected a digit or %q, found %q", sep, c)
+		}
+
+		digits++
+		n = n*10 + int64(c-'0')
+		if n > 1<<48 {
+			return 0, p.errorf(-1, "length is too large")
+		}
+	}
+}
+
+// readBytes reads exactly n bytes into buf. The buffer grows as data arrives,
+// so a bogus length can't cause a huge allocation up front.
+func (p *makeParser) readBytes(buf *bytes.Buffer, n int64) error {
+	buf.Reset()
+	read, err := io.CopyN(buf, p.r, n)
+	p.offset += read
+	if err == io.EOF {
+		return p.errorf(0, "unexpected end of input, expected %d more bytes", n-read)
+	}
+
+	return err
+}
+
+// expect checks that the next bytes are exactly s.
+func (p *makeParser) expect(s string) error {
+	for i := 0; i < len(s); i++ {
+		c, err := p.readByte()
+		if err != nil {
+			return err
+		} else if c != s[i] {
+			return p.errorf(-1, "expected %q, found %q; the declared lengths may be wrong", s, c)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package cdb64
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMake(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	db, err := Make(f, strings.NewReader(expectedDump), nil)
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+		value, err := db.Get(record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, string(record[1]), string(value), msg)
+	}
+
+	// Dumping it again should round-trip exactly.
+	var buf bytes.Buffer
+	require.NoError(t, db.Dump(&buf))
+	assert.Equal(t, expectedDump, buf.String())
+}
+
+func TestBuildFromMap(t *testing.T) {
+	m := map[string][]byte{"foo": []byte("bar"), "baz": []byte("quuuux"), "": []byte("empty_key"), "empty": {}}
+
+	var buf Buffer
+	db, err := BuildFromMap(&buf, m)
+	require.NoError(t, err)
+	assert.Equal(t, len(m), db.Count())
+
+	seen := make(map[string][]byte)
+	require.NoError(t, db.ForEach(func(key, value []byte) error {
+		seen[string(key)] = append([]byte{}, value...)
+		return nil
+	}))
+
+	assert.Equal(t, m, seen)
+}
+
+func TestMakeMalformed(t *testing.T) {
+	cases := map[string]int64{
+		"":                     0,
+		"+3,3:foo->bar\n":      14,
+		"-3,3:foo->bar\n\n":    0,
+		"+3,3:fooo->bar\n\n":   8,
+		"+3,3:foo->barr\n\n":   13,
+		"+3,3:foo=>bar\n\n":    8,
+		"+,3:foo->bar\n\n":     1,
+		"+3;3:foo->bar\n\n":    2,
+		"+3,30:foo->bar\n\n":   16,
+		"+3,3:foo->bar\n+1,\n": 17,
+	}
+
+	for input, offset := range cases {
+		f, err := ioutil.TempFile("", "test-cdb")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+
+		_, err = Make(f, strings.NewReader(input), nil)
+		var parseErr *ParseError
+		if assert.ErrorAs(t, err, &parseErr, "for input %q", input) {
+			assert.Equal(t, offset, parseErr.Offset, "for input %q: %s", input, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package cdb64
+
+import "os"
+
+// OpenMmap opens an existing CDB database at the given path, and maps it into
+// memory. Lookups are then served directly from the mapped file, rather than
+// with a ReadAt call for every tuple: reading the hash tables and comparing
+// keys doesn't allocate at all. Get still copies values out of the mapping,
+// so they stay valid after Close.
+//
+// The database must not be used after Close, which unmaps the file, including
+// by any Get still in progress.
+//
+// Memory mapping is supported on unix platforms. Elsewhere, OpenMmap reads the
+// whole file into memory instead.
+func OpenMmap(path string) (*CDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The mapping stays valid after the file is closed.
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := mmap(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := New(&mmapReader{bytesReader{data: data}}, nil)
+	if err != nil {
+		munmap(data)
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// mmapReader is an io.ReaderAt over a memory-mapped file.
+type mmapReader struct {
+	bytesReader
+}
+
+// Close unmaps the file.
+func (r *mmapReader) Close() error {
+	data := r.data
+	r.data = nil
+	return munmap(data)
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package cdb64
+
+import (
+	"io"
+	"os"
+)
+
+// mmap isn't available, so read the whole file into memory instead.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(f, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func munmap(data []byte) error {
+	return nil
+}
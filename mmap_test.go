@@ -0,0 +1,98 @@
+package cdb64
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMmap(t *testing.T) {
+	db, err := OpenMmap("./test/test.cdb")
+	require.NoError(t, err)
+	require.NotNil(t, db)
+
+	records := append(append(expectedRecords, expectedRecords...), expectedRecords...)
+	shuffle(records)
+
+	for _, record := range records {
+		msg := "while fetching " + string(record[0])
+
+		value, err := db.Get(record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, string(record[1]), string(value), msg)
+
+		found, err := db.Has(record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, record[1] != nil, found, msg)
+	}
+
+	n := 0
+	iter := db.Iter()
+	for iter.Next() {
+		assert.Equal(t, string(expectedRecords[n][0]), string(iter.Key()))
+		assert.Equal(t, string(expectedRecords[n][1]), string(iter.Value()))
+		n++
+	}
+
+	require.NoError(t, iter.Err())
+	assert.Equal(t, len(expectedRecords)-1, n)
+	assert.NoError(t, db.Verify())
+
+	require.NoError(t, db.Close())
+}
+
+func TestGetUnsafe(t *testing.T) {
+	for _, open := range []func(string) (*CDB, error){Open, OpenMmap} {
+		db, err := open("./test/test.cdb")
+		require.NoError(t, err)
+
+		for _, record := range expectedRecords {
+			msg := "while fetching " + string(record[0])
+
+			value, err := db.GetUnsafe(record[0])
+			require.NoError(t, err, msg)
+			assert.Equal(t, record[1] == nil, value == nil, msg)
+			assert.Equal(t, string(record[1]), string(value), msg)
+		}
+
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestOpenMmapMissing(t *testing.T) {
+	_, err := OpenMmap("./test/does-not-exist.cdb")
+	assert.Error(t, err)
+}
+
+func BenchmarkGetUnsafeMmap(b *testing.B) {
+	db, err := OpenMmap("./test/test.cdb")
+	require.NoError(b, err)
+	defer db.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	rand.Seed(time.Now().UnixNano())
+	for i := 0; i < b.N; i++ {
+		record := expectedRecords[rand.Intn(len(expectedRecords))]
+		db.GetUnsafe(record[0])
+	}
+}
+
+func BenchmarkGetMmap(b *testing.B) {
+	db, err := OpenMmap("./test/test.cdb")
+	require.NoError(b, err)
+	defer db.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	rand.Seed(time.Now().UnixNano())
+	for i := 0; i < b.N; i++ {
+		record := expectedRecords[rand.Intn(len(expectedRecords))]
+		db.Get(record[0])
+	}
+}
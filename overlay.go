@@ -0,0 +1,70 @@
+package cdb64
+
+import "bytes"
+
+// Overlay layers several databases on top of each other, so that small
+// databases of recent changes can be shipped without rewriting a large base.
+// Lookups check each layer in turn, newest first, and return the first hit.
+// Like CDB, it's safe for concurrent use.
+//
+// A lookup for a key that's missing from every layer reads every layer, so
+// the cost of a miss grows with the number of layers. To keep it in check,
+// periodically rewrite the layers into a new base, for example by iterating
+// over them with a Writer that skips keys it's already seen.
+type Overlay struct {
+	layers    []*CDB
+	tombstone []byte
+}
+
+// NewOverlay combines layers, ordered newest first, into an Overlay. If
+// tombstone isn't nil, a record whose value is exactly tombstone marks its key
+// as deleted: lookups stop at that layer and report the key as missing, even
+// if an older layer has it.
+func NewOverlay(layers []*CDB, tombstone []byte) *Overlay {
+	return &Overlay{layers: layers, tombstone: tombstone}
+}
+
+// Get returns the value for a given key from the newest layer that has it, or
+// nil if none of them do.
+func (o *Overlay) Get(key []byte) ([]byte, error) {
+	value, _, err := o.GetOK(key)
+	return value, err
+}
+
+// GetOK is like Get, but also returns whether the key was found at all.
+func (o *Overlay) GetOK(key []byte) ([]byte, bool, error) {
+	for _, layer := range o.layers {
+		value, ok, err := layer.GetOK(key)
+		if err != nil {
+			return nil, false, err
+		} else if !ok {
+			continue
+		}
+
+		if o.tombstone != nil && bytes.Equal(value, o.tombstone) {
+			return nil, false, nil
+		}
+
+		return value, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// Layers returns the layers of the overlay, newest first.
+func (o *Overlay) Layers() []*CDB {
+	return o.layers
+}
+
+// Close closes all the layers, and returns the first error.
+func (o *Overlay) Close() error {
+	var first error
+	for _, db := range o.layers {
+		err := db.Close()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
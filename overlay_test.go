@@ -0,0 +1,51 @@
+package cdb64
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLayer(t *testing.T, records ...string) *CDB {
+	var buf Buffer
+	writer, err := NewWriter(&buf, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < len(records); i += 2 {
+		require.NoError(t, writer.Put([]byte(records[i]), []byte(records[i+1])))
+	}
+
+	require.NoError(t, writer.Close())
+	db, err := FromBytes(buf.Bytes(), nil)
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestOverlay(t *testing.T) {
+	base := newLayer(t, "a", "base", "b", "base", "c", "base")
+	delta := newLayer(t, "b", "delta", "c", "\x00deleted", "d", "delta")
+	overlay := NewOverlay([]*CDB{delta, base}, []byte("\x00deleted"))
+	defer overlay.Close()
+
+	for key, expected := range map[string]string{"a": "base", "b": "delta", "d": "delta"} {
+		value, ok, err := overlay.GetOK([]byte(key))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, expected, string(value))
+	}
+
+	for _, key := range []string{"c", "missing"} {
+		value, err := overlay.Get([]byte(key))
+		require.NoError(t, err)
+		assert.Nil(t, value)
+	}
+
+	// Without a tombstone, the marker is just a value.
+	value, err := NewOverlay([]*CDB{delta, base}, nil).Get([]byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, "\x00deleted", string(value))
+
+	assert.Equal(t, []*CDB{delta, base}, overlay.Layers())
+}
@@ -0,0 +1,134 @@
+package cdb64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// ErrNoSortedKeys is returned by ScanPrefix's iterator for databases written
+// without WriterOptions.SortedKeys.
+var ErrNoSortedKeys = errors.New("database has no sorted key index")
+
+// buildSortedKeys reads back the key of every entry, and returns the index
+// section: the offsets of the records, in order of their keys. Records with
+// the same key stay in the order they were written.
+func (cdb *Writer) buildSortedKeys() ([]byte, error) {
+	err := cdb.bufferedWriter.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	type record struct {
+		key    []byte
+		offset uint64
+	}
+
+	r := cdb.writer.(io.ReaderAt)
+	var records []record
+	for i := range cdb.entries {
+		tableEntries, err := cdb.tableEntries(i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range tableEntries {
+			keyLength, _, err := readTuple(r, entry.offset)
+			if err != nil {
+				return nil, err
+			}
+
+			key, err := readAt(r, entry.offset+16, keyLength, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			records = append(records, record{key: key, offset: entry.offset})
+		}
+	}
+
+	sort.Slice(records, func(a, b int) bool {
+		if c := bytes.Compare(records[a].key, records[b].key); c != 0 {
+			return c < 0
+		}
+
+		return records[a].offset < records[b].offset
+	})
+
+	payload := make([]byte, 8*len(records))
+	for i, record := range records {
+		binary.LittleEndian.PutUint64(payload[8*i:], record.offset)
+	}
+
+	return payload, nil
+}
+
+// ScanPrefix returns an Iterator over the records whose keys start with
+// prefix, in order of their keys. An empty prefix matches every record.
+//
+// It needs the sorted key index written by WriterOptions.SortedKeys, which it
+// binary searches for the first matching key, so it reads O(log n) keys
+// before the first record. For databases without the index, the iterator
+// stops straight away, and its Err method returns ErrNoSortedKeys.
+func (cdb *CDB) ScanPrefix(prefix []byte) *Iterator {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	iter := &Iterator{db: cdb.snapshot()}
+	s, ok := cdb.sections[sectionSortedKeys]
+	if cdb.flags&flagSortedKeys == 0 || !ok {
+		iter.err = ErrNoSortedKeys
+		return iter
+	}
+
+	var err error
+	n := s.length / 8
+	first := sort.Search(int(n), func(i int) bool {
+		if err != nil {
+			return true
+		}
+
+		var key []byte
+		key, err = cdb.sortedKeyAt(s, uint64(i))
+		return bytes.Compare(key, prefix) >= 0
+	})
+
+	iter.err = err
+	iter.sorted = &s
+	iter.index = uint64(first)
+	iter.prefix = prefix
+	return iter
+}
+
+// sortedOffsetAt returns the offset of the i-th record in the sorted key
+// index s.
+func (cdb *CDB) sortedOffsetAt(s section, i uint64) (uint64, error) {
+	buf, err := readAt(cdb.reader, s.offset+8*i, 8, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// sortedKeyAt returns the key of the i-th record in the sorted key index s.
+func (cdb *CDB) sortedKeyAt(s section, i uint64) ([]byte, error) {
+	offset, err := cdb.sortedOffsetAt(s, i)
+	if err != nil {
+		return nil, err
+	}
+
+	keyLength, _, err := readTuple(cdb.reader, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = cdb.recordEnd(offset, keyLength, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return readAt(cdb.reader, offset+16, keyLength, nil)
+}
@@ -0,0 +1,72 @@
+package cdb64
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scanPrefix(t *testing.T, db *CDB, prefix string) []string {
+	var keys []string
+	iter := db.ScanPrefix([]byte(prefix))
+	for iter.Next() {
+		keys = append(keys, string(iter.Key())+"="+string(iter.Value()))
+	}
+
+	require.NoError(t, iter.Err())
+	return keys
+}
+
+func TestScanPrefix(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{SortedKeys: true})
+	require.NoError(t, err)
+
+	for _, key := range []string{"banana", "apple", "apricot", "cherry", "app", "b", "apple"} {
+		require.NoError(t, writer.Put([]byte(key), bytes.ToUpper([]byte(key))))
+	}
+	require.NoError(t, writer.Put([]byte("deleted"), []byte("x")))
+	require.NoError(t, writer.Delete([]byte("deleted")))
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, []string{"app=APP", "apple=APPLE", "apple=APPLE", "apricot=APRICOT"}, scanPrefix(t, db, "ap"))
+	assert.Equal(t, []string{"app=APP", "apple=APPLE", "apple=APPLE"}, scanPrefix(t, db, "app"))
+	assert.Equal(t, []string{"b=B", "banana=BANANA"}, scanPrefix(t, db, "b"))
+	assert.Equal(t, []string{"cherry=CHERRY"}, scanPrefix(t, db, "cherry"))
+	assert.Nil(t, scanPrefix(t, db, "cherryx"))
+	assert.Nil(t, scanPrefix(t, db, "zzz"))
+	assert.Nil(t, scanPrefix(t, db, "0"))
+	assert.Equal(t, 7, len(scanPrefix(t, db, "")))
+}
+
+func TestScanPrefixWithoutIndex(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	iter := db.ScanPrefix([]byte("foo"))
+	assert.False(t, iter.Next())
+	assert.Equal(t, ErrNoSortedKeys, iter.Err())
+}
+
+func TestSortedKeysNeedsReaderAt(t *testing.T) {
+	// Stream writers build the database in a temporary file, which works.
+	writer, err := NewStreamWriterWithOptions(ioutil.Discard, WriterOptions{SortedKeys: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	_, err = NewWriterWithOptions(struct{ io.WriteSeeker }{}, WriterOptions{SortedKeys: true})
+	assert.Error(t, err)
+}
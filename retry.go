@@ -0,0 +1,63 @@
+package cdb64
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// RetryingReaderAt is an io.ReaderAt that retries failed reads from an
+// underlying io.ReaderAt, for backends with transient errors, such as network
+// storage. Wrap the reader before passing it to New, and a single flaky read
+// won't fail a whole lookup. It's safe for concurrent use if the underlying
+// reader is.
+type RetryingReaderAt struct {
+	reader   io.ReaderAt
+	attempts int
+	backoff  time.Duration
+}
+
+// NewRetryingReaderAt returns a RetryingReaderAt that tries each read from r up
+// to the given number of attempts, sleeping for backoff after the first
+// failure, and twice as long after each one after that. io.EOF and
+// io.ErrUnexpectedEOF mean the read ran past the end of r, so they're
+// returned straight away, as is any error after the last attempt.
+func NewRetryingReaderAt(r io.ReaderAt, attempts int, backoff time.Duration) *RetryingReaderAt {
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	return &RetryingReaderAt{
+		reader:   r,
+		attempts: attempts,
+		backoff:  backoff,
+	}
+}
+
+// ReadAt implements io.ReaderAt. After a short read with an error, only the
+// rest of p is read again.
+func (r *RetryingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	delay := r.backoff
+	for attempt := 1; ; attempt++ {
+		m, err := r.reader.ReadAt(p[n:], off+int64(n))
+		n += m
+		if err == nil || n == len(p) {
+			return n, nil
+		} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || attempt >= r.attempts {
+			return n, err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Close closes the underlying reader, if it's an io.Closer.
+func (r *RetryingReaderAt) Close() error {
+	if closer, ok := r.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
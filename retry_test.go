@@ -0,0 +1,90 @@
+package cdb64
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyReaderAt fails every other read, after reading half of what it was
+// asked for.
+type flakyReaderAt struct {
+	io.ReaderAt
+
+	mu    sync.Mutex
+	reads int
+}
+
+var errFlaky = errors.New("flaky read")
+
+func (r *flakyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	r.reads++
+	fail := r.reads%2 == 1
+	r.mu.Unlock()
+
+	if fail {
+		n, _ := r.ReaderAt.ReadAt(p[:len(p)/2], off)
+		return n, errFlaky
+	}
+
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func TestRetryingReaderAt(t *testing.T) {
+	f, err := os.Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Without retries, lookups fail.
+	flaky := &flakyReaderAt{ReaderAt: f}
+	_, err = New(flaky, nil)
+	assert.ErrorIs(t, err, errFlaky)
+
+	db, err := New(NewRetryingReaderAt(&flakyReaderAt{ReaderAt: f}, 2, 0), nil)
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+
+		value, err := db.Get(record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, string(record[1]), string(value), msg)
+	}
+
+	require.NoError(t, db.Verify())
+}
+
+func TestRetryingReaderAtEOF(t *testing.T) {
+	f, err := os.Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer f.Close()
+
+	info, err := f.Stat()
+	require.NoError(t, err)
+
+	// Reading past the end isn't retried.
+	flaky := &flakyReaderAt{ReaderAt: f, reads: 1}
+	r := NewRetryingReaderAt(flaky, 5, 0)
+	buf := make([]byte, 32)
+	n, err := r.ReadAt(buf, info.Size()-16)
+	assert.Equal(t, 16, n)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, flaky.reads)
+
+	// Persistent errors are returned after the last attempt.
+	r = NewRetryingReaderAt(alwaysFails{}, 3, 0)
+	_, err = r.ReadAt(buf, 0)
+	assert.ErrorIs(t, err, errFlaky)
+}
+
+type alwaysFails struct{}
+
+func (alwaysFails) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errFlaky
+}
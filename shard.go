@@ -0,0 +1,337 @@
+package cdb64
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrShardMismatch is returned (wrapped with details) when the databases
+// passed to OpenSharded or NewSharded aren't exactly the shards of one
+// database written with a ShardedWriter.
+var ErrShardMismatch = errors.New("databases aren't the shards of one database")
+
+// shardID identifies one shard of a sharded database. It's stored in the
+// trailer of every shard, so that a reader can check it was given all of them.
+type shardID struct {
+	index uint32
+	count uint32
+}
+
+func (s shardID) marshal() []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf[0:4], s.index)
+	binary.LittleEndian.PutUint32(buf[4:8], s.count)
+
+	return buf
+}
+
+// readShardID reads the shard section of cdb, if there is one.
+func (cdb *CDB) readShardID() (shardID, bool, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	s, ok := cdb.sections[sectionShard]
+	if !ok || s.length != 8 {
+		return shardID{}, false, nil
+	}
+
+	buf := make([]byte, 8)
+	_, err := cdb.reader.ReadAt(buf, int64(s.offset))
+	if err != nil {
+		return shardID{}, false, err
+	}
+
+	return shardID{
+		index: binary.LittleEndian.Uint32(buf[0:4]),
+		count: binary.LittleEndian.Uint32(buf[4:8]),
+	}, true, nil
+}
+
+// shardHashers hands out hashers for picking shards, so that concurrent calls
+// never share hasher state.
+type shardHashers struct {
+	pool sync.Pool
+}
+
+func newShardHashers(shard HashFunc) *shardHashers {
+	if shard == nil {
+		shard = defaultShardHash
+	}
+
+	return &shardHashers{pool: sync.Pool{
+		New: func() interface{} { return shard() },
+	}}
+}
+
+// pick returns the index of the shard key belongs in, out of n.
+func (s *shardHashers) pick(key []byte, n int) int {
+	hasher := s.pool.Get().(hash.Hash64)
+	hasher.Reset()
+	hasher.Write(key)
+	shard := hasher.Sum64() % uint64(n)
+	s.pool.Put(hasher)
+
+	return int(shard)
+}
+
+func defaultShardHash() hash.Hash64 {
+	return fnv.New64a()
+}
+
+// ShardedWriterOptions configures a ShardedWriter.
+type ShardedWriterOptions struct {
+	// WriterOptions configures the Writer for every shard. Shards are always
+	// versioned, since they record which shard they are.
+	WriterOptions
+
+	// Shard is the hash function used to pick the shard for each key, by
+	// taking the hash modulo the number of shards. If nil, it defaults to
+	// FNV-1a. It should be a different function from Hasher: otherwise, with a
+	// power of two shards, the keys in each shard would all land in the same
+	// few hash tables.
+	Shard HashFunc
+}
+
+// ShardedWriter builds a database split across several files, or shards,
+// each of which is an ordinary database holding a fraction of the keys. The
+// shards are finalized in parallel, which spreads the work of building a very
+// large database across all cores. Use OpenSharded to read it back.
+//
+// Unlike a Writer, a ShardedWriter is safe for concurrent use: records for
+// different shards are written in parallel.
+type ShardedWriter struct {
+	shards []*Writer
+	locks  []sync.Mutex
+	shard  *shardHashers
+}
+
+// NewShardedWriter opens a sharded database with one shard for each of the
+// given writers, configured by opts. The writers are usually files, and must
+// all be kept together, in any order, to open the database again.
+func NewShardedWriter(writers []io.WriteSeeker, opts ShardedWriterOptions) (*ShardedWriter, error) {
+	if len(writers) == 0 {
+		return nil, errors.New("a sharded database needs at least one shard")
+	}
+
+	cdb := &ShardedWriter{
+		shards: make([]*Writer, len(writers)),
+		locks:  make([]sync.Mutex, len(writers)),
+		shard:  newShardHashers(opts.Shard),
+	}
+
+	wopts := opts.WriterOptions
+	wopts.Versioned = true
+	for i, writer := range writers {
+		shard, err := NewWriterWithOptions(writer, wopts)
+		if err != nil {
+			return nil, err
+		}
+
+		shard.shard = &shardID{index: uint32(i), count: uint32(len(writers))}
+		cdb.shards[i] = shard
+	}
+
+	return cdb, nil
+}
+
+// CreateSharded creates a sharded database with one shard at each of the
+// given paths. Existing files will be overwritten.
+func CreateSharded(paths []string, opts ShardedWriterOptions) (*ShardedWriter, error) {
+	writers := make([]io.WriteSeeker, len(paths))
+
+	// On failure, close and remove the files created so far.
+	cleanup := func(created int) {
+		for i, w := range writers[:created] {
+			w.(*os.File).Close()
+			os.Remove(paths[i])
+		}
+	}
+
+	for i, path := range paths {
+		f, err := os.Create(path)
+		if err != nil {
+			cleanup(i)
+			return nil, err
+		}
+
+		writers[i] = f
+	}
+
+	cdb, err := NewShardedWriter(writers, opts)
+	if err != nil {
+		cleanup(len(paths))
+		return nil, err
+	}
+
+	return cdb, nil
+}
+
+// Put adds a key/value pair to the shard it belongs in.
+func (cdb *ShardedWriter) Put(key, value []byte) error {
+	i := cdb.shard.pick(key, len(cdb.shards))
+	cdb.locks[i].Lock()
+	defer cdb.locks[i].Unlock()
+
+	return cdb.shards[i].Put(key, value)
+}
+
+// Close finalizes all the shards in parallel, then closes them to further
+// writes. It returns the first error from any shard.
+func (cdb *ShardedWriter) Close() error {
+	return cdb.parallel(func(i int, shard *Writer) error {
+		return shard.Close()
+	})
+}
+
+// Freeze finalizes all the shards in parallel, then opens them for reads as a
+// ShardedCDB. As with Writer.Freeze, every shard must be an io.ReaderAt.
+func (cdb *ShardedWriter) Freeze() (*ShardedCDB, error) {
+	dbs := make([]*CDB, len(cdb.shards))
+	err := cdb.parallel(func(i int, shard *Writer) error {
+		var err error
+		dbs[i], err = shard.Freeze()
+		return err
+	})
+	if err != nil {
+		for _, db := range dbs {
+			if db != nil {
+				db.Close()
+			}
+		}
+
+		return nil, err
+	}
+
+	return &ShardedCDB{shards: dbs, shard: cdb.shard}, nil
+}
+
+// parallel calls fn for every shard at once, and returns the first error.
+func (cdb *ShardedWriter) parallel(fn func(i int, shard *Writer) error) error {
+	errs := make([]error, len(cdb.shards))
+	var wg sync.WaitGroup
+	for i, shard := range cdb.shards {
+		wg.Add(1)
+		go func(i int, shard *Writer) {
+			defer wg.Done()
+
+			cdb.locks[i].Lock()
+			defer cdb.locks[i].Unlock()
+
+			errs[i] = fn(i, shard)
+		}(i, shard)
+	}
+
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ShardedCDB reads a database written with a ShardedWriter, routing each
+// lookup to the shard its key belongs in. Like CDB, it's safe for concurrent
+// use.
+type ShardedCDB struct {
+	shards []*CDB
+	shard  *shardHashers
+}
+
+// OpenSharded opens the shards of a sharded database at the given paths, in
+// any order. hasher and shard must be the Hasher and Shard the database was
+// written with; as usual, nil selects the default for each.
+func OpenSharded(paths []string, hasher, shard HashFunc) (*ShardedCDB, error) {
+	dbs := make([]*CDB, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err == nil {
+			var db *CDB
+			db, err = New(f, hasher)
+			if err == nil {
+				dbs = append(dbs, db)
+				continue
+			}
+
+			f.Close()
+		}
+
+		for _, db := range dbs {
+			db.Close()
+		}
+
+		return nil, err
+	}
+
+	sharded, err := NewSharded(dbs, shard)
+	if err != nil {
+		for _, db := range dbs {
+			db.Close()
+		}
+
+		return nil, err
+	}
+
+	return sharded, nil
+}
+
+// NewSharded combines already open shards, in any order, into a ShardedCDB.
+// Every shard of the database must be there exactly once; otherwise it
+// returns an error wrapping ErrShardMismatch. shard must be the Shard hash
+// function the database was written with, or nil for the default.
+func NewSharded(dbs []*CDB, shard HashFunc) (*ShardedCDB, error) {
+	shards := make([]*CDB, len(dbs))
+	for _, db := range dbs {
+		id, ok, err := db.readShardID()
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("%w: database isn't a shard", ErrShardMismatch)
+		} else if int(id.count) != len(dbs) {
+			return nil, fmt.Errorf("%w: database has %d shards, but %d were given", ErrShardMismatch, id.count, len(dbs))
+		} else if id.index >= id.count {
+			return nil, fmt.Errorf("%w: database is shard %d of only %d", ErrShardMismatch, id.index, id.count)
+		} else if shards[id.index] != nil {
+			return nil, fmt.Errorf("%w: shard %d was given twice", ErrShardMismatch, id.index)
+		}
+
+		shards[id.index] = db
+	}
+
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("%w: no shards were given", ErrShardMismatch)
+	}
+
+	return &ShardedCDB{shards: shards, shard: newShardHashers(shard)}, nil
+}
+
+// Get returns the value for a given key, or nil if it can't be found.
+func (cdb *ShardedCDB) Get(key []byte) ([]byte, error) {
+	return cdb.shards[cdb.shard.pick(key, len(cdb.shards))].Get(key)
+}
+
+// Shards returns the shards of the database, in order. Each is an ordinary
+// database, holding the keys that belong in it.
+func (cdb *ShardedCDB) Shards() []*CDB {
+	return cdb.shards
+}
+
+// Close closes all the shards, and returns the first error.
+func (cdb *ShardedCDB) Close() error {
+	var first error
+	for _, db := range cdb.shards {
+		err := db.Close()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,125 @@
+package cdb64
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func shardPaths(t *testing.T, n int) (string, []string) {
+	dir, err := ioutil.TempDir("", "test-cdb-shards")
+	require.NoError(t, err)
+
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, "shard-"+strconv.Itoa(i)+".cdb")
+	}
+
+	return dir, paths
+}
+
+func TestShardedWriter(t *testing.T) {
+	dir, paths := shardPaths(t, 4)
+	defer os.RemoveAll(dir)
+
+	writer, err := CreateSharded(paths, ShardedWriterOptions{})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < 1000; i += 4 {
+				assert.NoError(t, writer.Put([]byte("key"+strconv.Itoa(i)), []byte(strconv.Itoa(i))))
+			}
+		}(g)
+	}
+
+	wg.Wait()
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+
+	records := 0
+	for _, shard := range db.Shards() {
+		require.NoError(t, shard.Verify())
+		records += shard.Stats().Records
+		assert.NotZero(t, shard.Stats().Records)
+	}
+
+	assert.Equal(t, 1000, records)
+	for i := 0; i < 1000; i++ {
+		value, err := db.Get([]byte("key" + strconv.Itoa(i)))
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(value))
+	}
+
+	value, err := db.Get([]byte("missing"))
+	require.NoError(t, err)
+	assert.Nil(t, value)
+	require.NoError(t, db.Close())
+
+	// The shards can be opened in any order.
+	db, err = OpenSharded([]string{paths[2], paths[0], paths[3], paths[1]}, nil, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		value, err := db.Get([]byte("key" + strconv.Itoa(i)))
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(value))
+	}
+}
+
+func TestOpenShardedMismatch(t *testing.T) {
+	dir, paths := shardPaths(t, 3)
+	defer os.RemoveAll(dir)
+
+	writer, err := CreateSharded(paths, ShardedWriterOptions{})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	_, err = OpenSharded(paths[:2], nil, nil)
+	assert.True(t, errors.Is(err, ErrShardMismatch), "got %v", err)
+
+	_, err = OpenSharded([]string{paths[0], paths[0], paths[1]}, nil, nil)
+	assert.True(t, errors.Is(err, ErrShardMismatch), "got %v", err)
+
+	_, err = OpenSharded([]string{"./test/test.cdb"}, nil, nil)
+	assert.True(t, errors.Is(err, ErrShardMismatch), "got %v", err)
+
+	// A shard whose index is out of range is rejected, rather than trusted.
+	var buf Buffer
+	shard, err := NewWriterWithOptions(&buf, WriterOptions{Versioned: true})
+	require.NoError(t, err)
+	shard.shard = &shardID{index: 5, count: 1}
+	require.NoError(t, shard.Close())
+
+	db, err := New(&buf, nil)
+	require.NoError(t, err)
+	_, err = NewSharded([]*CDB{db}, nil)
+	assert.True(t, errors.Is(err, ErrShardMismatch), "got %v", err)
+}
+
+func TestCreateShardedFails(t *testing.T) {
+	dir, paths := shardPaths(t, 3)
+	defer os.RemoveAll(dir)
+
+	// An invalid table count makes every shard's writer fail, and none of
+	// the files are left behind.
+	_, err := CreateSharded(paths, ShardedWriterOptions{WriterOptions: WriterOptions{TableCount: 3}})
+	assert.Error(t, err)
+
+	for _, path := range paths {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package cdb64
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// sortData rewrites the data section with the records in order of their keys,
+// and points the entries at their new offsets. Records without an entry, which
+// were deleted, are dropped.
+func (cdb *Writer) sortData() error {
+	err := cdb.bufferedWriter.Flush()
+	if err != nil {
+		return err
+	}
+
+	// Copy the records aside, so they can be written back over themselves.
+	start := int64(cdb.dataStart())
+	data := io.NewSectionReader(cdb.writer.(io.ReaderAt), start, cdb.bufferedOffset-start)
+	tmp, err := ioutil.TempFile(cdb.options.TempDir, "cdb64-sort")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	_, err = io.Copy(tmp, data)
+	if err != nil {
+		return err
+	}
+
+	type record struct {
+		key   []byte
+		entry *entry
+	}
+
+	var records []record
+	for i := range cdb.entries {
+		for j := range cdb.entries[i] {
+			entry := &cdb.entries[i][j]
+			keyLength, _, err := readTuple(tmp, entry.offset-uint64(start))
+			if err != nil {
+				return err
+			}
+
+			key, err := readAt(tmp, entry.offset-uint64(start)+16, keyLength, nil)
+			if err != nil {
+				return err
+			}
+
+			records = append(records, record{key: key, entry: entry})
+		}
+	}
+
+	sort.Slice(records, func(a, b int) bool {
+		if c := bytes.Compare(records[a].key, records[b].key); c != 0 {
+			return c < 0
+		}
+
+		return records[a].entry.offset < records[b].entry.offset
+	})
+
+	// Write them back in order. Starting over also resets the checksum, if
+	// there is one.
+	_, err = cdb.writer.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	cdb.startData(start)
+	buf := make([]byte, 32*1024)
+	for _, record := range records {
+		old := record.entry.offset - uint64(start)
+		keyLength, valueLength, err := readTuple(tmp, old)
+		if err != nil {
+			return err
+		}
+
+		size := int64(16 + keyLength + valueLength)
+		_, err = io.CopyBuffer(cdb.dataWriter, io.NewSectionReader(tmp, int64(old), size), buf)
+		if err != nil {
+			return err
+		}
+
+		record.entry.offset = uint64(cdb.bufferedOffset)
+		cdb.bufferedOffset += size
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortData(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{SortData: true, Checksum: true})
+	require.NoError(t, err)
+
+	var keys []string
+	for _, i := range rand.Perm(1000) {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		require.NoError(t, writer.Put([]byte(key), []byte(strconv.Itoa(i))))
+	}
+
+	// Duplicates keep their order, and deleted records are dropped.
+	require.NoError(t, writer.Put([]byte("key5"), []byte("second")))
+	require.NoError(t, writer.Put([]byte("deleted"), []byte("x")))
+	require.NoError(t, writer.Delete([]byte("deleted")))
+	require.NoError(t, writer.Close())
+
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Verify())
+	require.NoError(t, db.VerifyChecksum())
+
+	info, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, db.Size(), info.Size())
+
+	sort.Strings(keys)
+	var iterated []string
+	iter := db.Iter()
+	for iter.Next() {
+		iterated = append(iterated, string(iter.Key()))
+	}
+	require.NoError(t, iter.Err())
+
+	i := sort.SearchStrings(keys, "key5")
+	expected := append(append(append([]string{}, keys[:i+1]...), "key5"), keys[i+1:]...)
+	assert.Equal(t, expected, iterated)
+
+	values, err := db.GetAll([]byte("key5"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("5"), []byte("second")}, values)
+
+	for i := 0; i < 1000; i++ {
+		value, err := db.Get([]byte("key" + strconv.Itoa(i)))
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(value))
+	}
+}
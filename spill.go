@@ -0,0 +1,207 @@
+package cdb64
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+)
+
+// spillFile holds hash table entries that have been moved out of memory, for
+// WriterOptions.MaxMemory. Each time the entries in memory fill the budget,
+// they're appended to the file as a run, with one segment per hash table. A
+// table's entries are its segments from each run in turn, followed by
+// whatever is still in memory, which keeps them in the order they were
+// written.
+type spillFile struct {
+	file  *os.File
+	runs  [][]spillSegment
+	end   int64
+	count int
+}
+
+// spillEntrySize is the size of an entry, both in memory and in the spill
+// file.
+const spillEntrySize = 24
+
+// spillSegment is the location of one table's entries within a run.
+type spillSegment struct {
+	offset int64
+	count  int
+}
+
+// addEntry records an entry in the given hash table, moving the entries in
+// memory out to the spill file if they've grown past WriterOptions.MaxMemory.
+func (cdb *Writer) addEntry(table uint64, e entry) error {
+	cdb.entries[table] = append(cdb.entries[table], e)
+	cdb.memEntries++
+	if cdb.options.MaxMemory > 0 && int64(cdb.memEntries)*spillEntrySize >= cdb.options.MaxMemory {
+		return cdb.spillEntries()
+	}
+
+	return nil
+}
+
+// spillEntries appends every entry in memory to the spill file as a new run.
+func (cdb *Writer) spillEntries() error {
+	if cdb.spill == nil {
+		f, err := ioutil.TempFile(cdb.options.TempDir, "cdb64-spill")
+		if err != nil {
+			return err
+		}
+
+		cdb.spill = &spillFile{file: f}
+	}
+
+	s := cdb.spill
+	w := bufio.NewWriterSize(s.file, cdb.options.BufferSize)
+	run := make([]spillSegment, len(cdb.entries))
+	for i, tableEntries := range cdb.entries {
+		run[i] = spillSegment{offset: s.end, count: len(tableEntries)}
+		for _, entry := range tableEntries {
+			err := writeTuple(w, entry.hash, entry.offset)
+			if err == nil {
+				err = writeUint64(w, entry.check)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		s.end += spillEntrySize * int64(len(tableEntries))
+		s.count += len(tableEntries)
+	}
+
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	// Only forget the entries once they're safely written.
+	for i := range cdb.entries {
+		cdb.entries[i] = cdb.entries[i][:0]
+	}
+
+	s.runs = append(s.runs, run)
+	cdb.memEntries = 0
+	return nil
+}
+
+// tableEntries returns all the entries for a hash table, in the order they
+// were written, including any in the spill file. Without a spill file, it's
+// the slice held in memory, which must not be modified.
+func (cdb *Writer) tableEntries(table int) ([]entry, error) {
+	if cdb.spill == nil {
+		return cdb.entries[table], nil
+	}
+
+	var all []entry
+	for _, run := range cdb.spill.runs {
+		entries, err := cdb.spill.read(run[table])
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, entries...)
+	}
+
+	return append(all, cdb.entries[table]...), nil
+}
+
+// filterEntries drops every entry in a hash table for which deleted returns
+// true, both in memory and in the spill file, and returns how many it
+// dropped.
+func (cdb *Writer) filterEntries(table uint64, deleted func(entry) (bool, error)) (int, error) {
+	dropped := 0
+	if cdb.spill != nil {
+		for _, run := range cdb.spill.runs {
+			segment := &run[table]
+			entries, err := cdb.spill.read(*segment)
+			if err != nil {
+				return dropped, err
+			}
+
+			kept := entries[:0]
+			for _, entry := range entries {
+				drop, err := deleted(entry)
+				if err != nil {
+					return dropped, err
+				} else if !drop {
+					kept = append(kept, entry)
+				}
+			}
+
+			if len(kept) == len(entries) {
+				continue
+			}
+
+			// Rewrite the segment in place; it can only shrink.
+			err = cdb.spill.write(segment.offset, kept)
+			if err != nil {
+				return dropped, err
+			}
+
+			dropped += len(entries) - len(kept)
+			cdb.spill.count -= len(entries) - len(kept)
+			segment.count = len(kept)
+		}
+	}
+
+	kept := cdb.entries[table][:0]
+	for _, entry := range cdb.entries[table] {
+		drop, err := deleted(entry)
+		if err != nil {
+			return dropped, err
+		} else if !drop {
+			kept = append(kept, entry)
+		}
+	}
+
+	dropped += len(cdb.entries[table]) - len(kept)
+	cdb.memEntries -= len(cdb.entries[table]) - len(kept)
+	cdb.entries[table] = kept
+	return dropped, nil
+}
+
+// removeSpill deletes the spill file, if there is one.
+func (cdb *Writer) removeSpill() {
+	if cdb.spill != nil {
+		cdb.spill.file.Close()
+		os.Remove(cdb.spill.file.Name())
+		cdb.spill = nil
+	}
+}
+
+// read reads the entries in a segment.
+func (s *spillFile) read(segment spillSegment) ([]entry, error) {
+	buf := make([]byte, spillEntrySize*segment.count)
+	_, err := s.file.ReadAt(buf, segment.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, segment.count)
+	for i := range entries {
+		entries[i] = entry{
+			hash:   binary.LittleEndian.Uint64(buf[spillEntrySize*i:]),
+			offset: binary.LittleEndian.Uint64(buf[spillEntrySize*i+8:]),
+			check:  binary.LittleEndian.Uint64(buf[spillEntrySize*i+16:]),
+		}
+	}
+
+	return entries, nil
+}
+
+// write writes entries at offset.
+func (s *spillFile) write(offset int64, entries []entry) error {
+	buf := make([]byte, spillEntrySize*len(entries))
+	for i, entry := range entries {
+		binary.LittleEndian.PutUint64(buf[spillEntrySize*i:], entry.hash)
+		binary.LittleEndian.PutUint64(buf[spillEntrySize*i+8:], entry.offset)
+		binary.LittleEndian.PutUint64(buf[spillEntrySize*i+16:], entry.check)
+	}
+
+	_, err := s.file.WriteAt(buf, offset)
+	return err
+}
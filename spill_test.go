@@ -0,0 +1,76 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-cdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	build := func(opts WriterOptions) []byte {
+		var buf Buffer
+		writer, err := NewWriterWithOptions(&buf, opts)
+		require.NoError(t, err)
+
+		for i := 0; i < 10000; i++ {
+			require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i*i))))
+		}
+
+		for i := 0; i < 10000; i += 7 {
+			require.NoError(t, writer.Delete([]byte(strconv.Itoa(i))))
+		}
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("again")))
+		}
+
+		assert.Equal(t, 10000-1429+100, writer.NumRecords())
+		assert.Equal(t, opts.MaxMemory > 0, writer.spill != nil)
+		require.NoError(t, writer.Close())
+		return buf.Bytes()
+	}
+
+	opts := WriterOptions{BloomFalsePositiveRate: 0.01, SortedKeys: true, TempDir: dir}
+	expected := build(opts)
+
+	// Moving the entries out of memory doesn't change the result.
+	opts.MaxMemory = 16 * 1000
+	data := build(opts)
+	assert.Equal(t, expected, data)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	db, err := FromBytes(data, nil)
+	require.NoError(t, err)
+
+	values, err := db.GetAll([]byte("14"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("again")}, values)
+
+	values, err = db.GetAll([]byte("15"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("225"), []byte("again")}, values)
+}
+
+func TestMaxMemoryRejectDuplicates(t *testing.T) {
+	var buf Buffer
+	writer, err := NewWriterWithOptions(&buf, WriterOptions{MaxMemory: 16 * 10, RejectDuplicates: true})
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("value")))
+	}
+
+	assert.ErrorIs(t, writer.Put([]byte("5"), []byte("again")), ErrDuplicateKey)
+	require.NoError(t, writer.Close())
+}
@@ -0,0 +1,156 @@
+package cdb64
+
+import (
+	"errors"
+	"hash/crc32"
+	"sort"
+)
+
+// Stats describes how records are distributed across a database's hash
+// tables.
+type Stats struct {
+	// Records is the total number of records in the database.
+	Records int
+
+	// Slots is the number of slots in each hash table, and Entries the number
+	// of records in each. There's one element per table, which is 256 unless
+	// the database was written with WriterOptions.TableCount.
+	Slots   []uint64
+	Entries []int
+
+	// LoadFactor is the fraction of all slots that are occupied.
+	LoadFactor float64
+
+	// MinEntries, MaxEntries and MeanEntries summarize the number of records
+	// per hash table. A wide spread between them means the hash function is
+	// distributing keys poorly.
+	MinEntries  int
+	MaxEntries  int
+	MeanEntries float64
+}
+
+// Stats returns statistics about the distribution of records across the hash
+// tables. It's computed from the header alone, without any I/O; see
+// AverageProbeLength for a more detailed measure.
+func (cdb *CDB) Stats() Stats {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	var slots uint64
+	stats := Stats{
+		Slots:   make([]uint64, len(cdb.header)),
+		Entries: make([]int, len(cdb.header)),
+	}
+
+	stats.MinEntries = -1
+	for i, table := range cdb.header {
+		entries := int(table.length / 2)
+		stats.Slots[i] = table.length
+		stats.Entries[i] = entries
+		stats.Records += entries
+		slots += table.length
+
+		if stats.MinEntries < 0 || entries < stats.MinEntries {
+			stats.MinEntries = entries
+		}
+
+		if entries > stats.MaxEntries {
+			stats.MaxEntries = entries
+		}
+	}
+
+	if slots > 0 {
+		stats.LoadFactor = float64(stats.Records) / float64(slots)
+	}
+
+	stats.MeanEntries = float64(stats.Records) / float64(len(cdb.header))
+	return stats
+}
+
+// AverageProbeLength returns the mean number of slots a successful lookup has
+// to check before finding its key, by measuring how far each occupied slot is
+// from the slot its hash points to. A perfect hash function would give 1.
+//
+// It reads all of the hash tables, but none of the records.
+func (cdb *CDB) AverageProbeLength() (float64, error) {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	var probes, entries uint64
+	for _, table := range cdb.header {
+		for slot := uint64(0); slot < table.length; slot++ {
+			hash, offset, err := readTuple(cdb.reader, table.offset+cdb.slotSize*slot)
+			if err != nil {
+				return 0, err
+			}
+
+			if offset == 0 {
+				continue
+			}
+
+			ideal := firstSlot(hash, cdb.tableBits, table.length)
+			probes += (slot+table.length-ideal)%table.length + 1
+			entries++
+		}
+	}
+
+	if entries == 0 {
+		return 0, nil
+	}
+
+	return float64(probes) / float64(entries), nil
+}
+
+// ValueSizeHistogram counts the records in the database by the length of
+// their values, reading only the length of each record, never its key or
+// value. buckets are the upper bounds of each bucket, in ascending order:
+// counts[i] is the number of values no longer than buckets[i], and longer
+// than buckets[i-1]. There's one more count than buckets, for values longer
+// than the last bound.
+//
+// Records are counted as they appear in the data section, so any that no
+// hash table points to are included. For databases written with a
+// ValueCodec, the lengths are of the values as stored, after encoding.
+func (cdb *CDB) ValueSizeHistogram(buckets []int64) ([]int, error) {
+	if !sort.SliceIsSorted(buckets, func(a, b int) bool { return buckets[a] < buckets[b] }) {
+		return nil, errors.New("buckets must be in ascending order")
+	}
+
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	// Record checksums and expiry times are stored as part of the value.
+	var overhead uint64
+	if cdb.flags&flagRecordChecksum != 0 {
+		overhead += crc32.Size
+	}
+
+	if cdb.flags&flagExpiry != 0 {
+		overhead += 8
+	}
+
+	counts := make([]int, len(buckets)+1)
+	pos := cdb.dataStart
+	for pos < cdb.header[0].offset {
+		keyLength, valueLength, err := readTuple(cdb.reader, pos)
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := cdb.recordEnd(pos, keyLength, valueLength)
+		if err != nil {
+			return nil, err
+		}
+
+		size := int64(0)
+		if valueLength > overhead {
+			size = int64(valueLength - overhead)
+		}
+
+		i := sort.Search(len(buckets), func(i int) bool { return buckets[i] >= size })
+		counts[i]++
+		pos = end
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,86 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+
+	stats := db.Stats()
+	assert.Equal(t, len(expectedRecords)-1, stats.Records)
+	assert.Equal(t, 0.5, stats.LoadFactor)
+	assert.Equal(t, 0, stats.MinEntries)
+	assert.Equal(t, 2, stats.MaxEntries)
+	assert.InDelta(t, 9.0/256, stats.MeanEntries, 1e-9)
+
+	total := 0
+	for i := range stats.Entries {
+		assert.Equal(t, uint64(stats.Entries[i]*2), stats.Slots[i])
+		total += stats.Entries[i]
+	}
+
+	assert.Equal(t, stats.Records, total)
+
+	probes, err := db.AverageProbeLength()
+	require.NoError(t, err)
+	assert.True(t, probes >= 1, "average probe length is %f", probes)
+}
+
+func TestStatsEmpty(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+
+	stats := db.Stats()
+	assert.Equal(t, 0, stats.Records)
+	assert.Equal(t, 0.0, stats.LoadFactor)
+	assert.Equal(t, 0, stats.MaxEntries)
+
+	probes, err := db.AverageProbeLength()
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, probes)
+}
+
+func TestValueSizeHistogram(t *testing.T) {
+	for _, opts := range []WriterOptions{{}, {RecordChecksums: true, Expiry: true}} {
+		var buf Buffer
+		writer, err := NewWriterWithOptions(&buf, opts)
+		require.NoError(t, err)
+
+		for _, size := range []int{0, 1, 10, 11, 100, 1000, 5000} {
+			require.NoError(t, writer.Put([]byte(strconv.Itoa(size)), make([]byte, size)))
+		}
+
+		db, err := writer.Freeze()
+		require.NoError(t, err)
+
+		counts, err := db.ValueSizeHistogram([]int64{0, 10, 100, 1000})
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 2, 1, 1}, counts)
+	}
+
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer db.Close()
+
+	counts, err := db.ValueSizeHistogram(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []int{len(expectedRecords) - 1}, counts)
+
+	_, err = db.ValueSizeHistogram([]int64{10, 1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package cdb64
+
+import "sync/atomic"
+
+// DB holds the current version of a database that's replaced from time to
+// time, such as one rebuilt periodically and swapped in whole. A CDB never
+// changes once it's open (except through Reopen), so a reader that calls Load
+// and uses the CDB it returns sees one version of the data throughout, even
+// if a new one is stored in the meantime:
+//
+//	value, err := db.Load().Get(key)
+//
+// A DB is safe for concurrent use by multiple goroutines.
+//
+// Storing a new version doesn't close the old one, since readers may still be
+// using it. Close it once they're done, for example after a grace period
+// longer than any lookup takes.
+type DB struct {
+	current atomic.Pointer[CDB]
+}
+
+// NewDB returns a DB holding db, which may be nil.
+func NewDB(db *CDB) *DB {
+	d := &DB{}
+	d.current.Store(db)
+	return d
+}
+
+// Load returns the current version of the database.
+func (d *DB) Load() *CDB {
+	return d.current.Load()
+}
+
+// Store makes db the current version of the database.
+func (d *DB) Store(db *CDB) {
+	d.current.Store(db)
+}
+
+// Swap makes db the current version of the database, and returns the previous
+// one, so that it can be closed once readers are done with it.
+func (d *DB) Swap(db *CDB) *CDB {
+	return d.current.Swap(db)
+}
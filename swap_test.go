@@ -0,0 +1,46 @@
+package cdb64
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBSwap(t *testing.T) {
+	versions := make([]*CDB, 10)
+	for i := range versions {
+		versions[i] = newLayer(t, "a", strconv.Itoa(i), "b", strconv.Itoa(i))
+	}
+
+	db := NewDB(versions[0])
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				// Both keys always come from the same version.
+				current := db.Load()
+				a, err := current.Get([]byte("a"))
+				assert.NoError(t, err)
+				b, err := current.Get([]byte("b"))
+				assert.NoError(t, err)
+				assert.Equal(t, string(a), string(b))
+			}
+		}()
+	}
+
+	for _, version := range versions[1:] {
+		old := db.Swap(version)
+		require.NotNil(t, old)
+	}
+
+	wg.Wait()
+	assert.Equal(t, versions[len(versions)-1], db.Load())
+
+	db.Store(versions[0])
+	assert.Equal(t, versions[0], db.Load())
+}
@@ -3,13 +3,176 @@ package cdb64
 import (
 	"encoding/binary"
 	"io"
+	"os"
+	"sync"
+	"unsafe"
 )
 
-func readTuple(r io.ReaderAt, offset uint64) (uint64, uint64, error) {
-	tuple := make([]byte, 16)
-	_, err := r.ReadAt(tuple, int64(offset))
+// byteSlicer is implemented by readers backed by memory, such as an mmapped
+// file, which can hand out their contents without copying.
+type byteSlicer interface {
+	slice(offset, length uint64) ([]byte, error)
+}
+
+// readAt returns length bytes of r, starting at offset. If r is a byteSlicer,
+// the result aliases its memory, and must not be modified; otherwise it's read
+// into buf, if it's large enough, or into a new slice.
+func readAt(r io.ReaderAt, offset, length uint64, buf []byte) ([]byte, error) {
+	if slicer, ok := r.(byteSlicer); ok {
+		return slicer.slice(offset, length)
+	}
+
+	if buf == nil || uint64(cap(buf)) < length {
+		buf = make([]byte, length)
+	} else {
+		buf = buf[:length]
+	}
+
+	_, err := r.ReadAt(buf, int64(offset))
 	if err != nil {
-		return 0, 0, err
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// bytesReader is an io.ReaderAt over a byte slice. As a byteSlicer, it lets
+// reads alias the slice rather than copying out of it.
+type bytesReader struct {
+	data []byte
+}
+
+func (r *bytesReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, os.ErrInvalid
+	} else if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (r *bytesReader) slice(offset, length uint64) ([]byte, error) {
+	size := uint64(len(r.data))
+	if offset > size || length > size-offset {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return r.data[offset : offset+length : offset+length], nil
+}
+
+// Size returns the length of the slice.
+func (r *bytesReader) Size() int64 {
+	return int64(len(r.data))
+}
+
+// sizedReader is an io.ReaderAt of a known size, for NewWithSize.
+type sizedReader struct {
+	io.ReaderAt
+	size int64
+}
+
+// ReadAt reads from the underlying reader, stopping at the end of the data.
+func (r *sizedReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	} else if int64(len(p)) > r.size-off {
+		n, err := r.ReaderAt.ReadAt(p[:r.size-off], off)
+		if err == nil {
+			err = io.EOF
+		}
+
+		return n, err
+	}
+
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+// Size returns the size the reader was created with.
+func (r *sizedReader) Size() int64 {
+	return r.size
+}
+
+// Close closes the underlying reader, if it's an io.Closer.
+func (r *sizedReader) Close() error {
+	if closer, ok := r.ReaderAt.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// offsetReader is an io.ReaderAt over the part of another one from base
+// onwards, for NewAt.
+type offsetReader struct {
+	io.ReaderAt
+	base int64
+}
+
+// ReadAt reads from the underlying reader, at off past base.
+func (r *offsetReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	return r.ReaderAt.ReadAt(p, r.base+off)
+}
+
+// Close closes the underlying reader, if it's an io.Closer.
+func (r *offsetReader) Close() error {
+	if closer, ok := r.ReaderAt.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// readerSize returns the total size of r, if it's something that knows its
+// size, such as an *os.File or a *bytes.Reader.
+func readerSize(r io.ReaderAt) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), true
+	case interface{ Stat() (os.FileInfo, error) }:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+
+		return info.Size(), true
+	}
+
+	return 0, false
+}
+
+// tuplePool holds buffers for readTuple, which is called for every slot a
+// lookup probes, so that reading from a plain io.ReaderAt doesn't allocate.
+var tuplePool = sync.Pool{
+	New: func() interface{} { return new([16]byte) },
+}
+
+func readTuple(r io.ReaderAt, offset uint64) (uint64, uint64, error) {
+	var tuple []byte
+	if slicer, ok := r.(byteSlicer); ok {
+		var err error
+		tuple, err = slicer.slice(offset, 16)
+		if err != nil {
+			return 0, 0, err
+		}
+	} else {
+		buf := tuplePool.Get().(*[16]byte)
+		defer tuplePool.Put(buf)
+
+		tuple = buf[:]
+		_, err := r.ReadAt(tuple, int64(offset))
+		if err != nil {
+			return 0, 0, err
+		}
 	}
 
 	first := binary.LittleEndian.Uint64(tuple[:8])
@@ -25,3 +188,22 @@ func writeTuple(w io.Writer, first, second uint64) error {
 	_, err := w.Write(tuple)
 	return err
 }
+
+func writeUint64(w io.Writer, v uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// stringBytes returns the bytes backing s without copying them. The result
+// must not be modified. An empty string gives an empty slice rather than nil,
+// since nil keys are rejected.
+func stringBytes(s string) []byte {
+	if len(s) == 0 {
+		return []byte{}
+	}
+
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
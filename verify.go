@@ -0,0 +1,201 @@
+package cdb64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+var (
+	// ErrCorrupt is returned (wrapped with details) when a database fails
+	// verification.
+	ErrCorrupt = errors.New("database is corrupt")
+
+	// ErrNoChecksum is returned by VerifyChecksum for databases written
+	// without a checksum.
+	ErrNoChecksum = errors.New("database has no checksum")
+
+	// ErrRecordCorrupt is returned (wrapped with the key) when reading a
+	// record whose value doesn't match its checksum, in databases written
+	// with WriterOptions.RecordChecksums.
+	ErrRecordCorrupt = errors.New("record is corrupt")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Verify checks the whole database for consistency. Every record in the data
+// section is read, its key is rehashed, and the matching hash table slot is
+// checked to point back at it. Every occupied slot in the hash tables is
+// checked to point at a record, and no record may run past the start of the
+// hash tables.
+//
+// It returns nil if the database is consistent, or an error wrapping
+// ErrCorrupt that names the first offset where something is wrong. Verify
+// reads the entire file, so it can take a while for large databases.
+func (cdb *CDB) Verify() error {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	dataEnd := cdb.header[0].offset
+	if dataEnd < cdb.dataStart {
+		return fmt.Errorf("%w: hash tables start at offset %d, inside the header", ErrCorrupt, dataEnd)
+	}
+
+	expectedOffset := dataEnd
+	for i, table := range cdb.header {
+		if table.offset != expectedOffset {
+			return fmt.Errorf("%w: hash table %d starts at offset %d, expected %d", ErrCorrupt, i, table.offset, expectedOffset)
+		}
+
+		expectedOffset += table.length * cdb.slotSize
+	}
+
+	// Walk the data section, and check every record can be found through the
+	// hash tables.
+	records := make(map[uint64]bool)
+	pos := cdb.dataStart
+	for pos < dataEnd {
+		keyLength, valueLength, err := readTuple(cdb.reader, pos)
+		if err != nil {
+			return fmt.Errorf("%w: reading record at offset %d: %s", ErrCorrupt, pos, err)
+		}
+
+		end, err := cdb.recordEnd(pos, keyLength, valueLength)
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, keyLength)
+		_, err = cdb.reader.ReadAt(key, int64(pos+16))
+		if err != nil {
+			return fmt.Errorf("%w: reading key at offset %d: %s", ErrCorrupt, pos+16, err)
+		}
+
+		if cdb.flags&flagRecordChecksum != 0 {
+			value := make([]byte, valueLength)
+			_, err = cdb.reader.ReadAt(value, int64(pos+16+keyLength))
+			if err != nil {
+				return fmt.Errorf("%w: reading value at offset %d: %s", ErrCorrupt, pos+16+keyLength, err)
+			}
+
+			_, err = checkRecord(key, value)
+			if err != nil {
+				return fmt.Errorf("%w: record at offset %d: %s", ErrCorrupt, pos, err)
+			}
+		}
+
+		found, err := cdb.slotPointsTo(cdb.hashKey(key), cdb.keyCheck(key), pos)
+		if err != nil {
+			return err
+		} else if !found {
+			return fmt.Errorf("%w: record at offset %d is missing from its hash table", ErrCorrupt, pos)
+		}
+
+		records[pos] = true
+		pos = end
+	}
+
+	// Then check every slot points at one of those records.
+	for i, table := range cdb.header {
+		for slot := uint64(0); slot < table.length; slot++ {
+			slotOffset := table.offset + cdb.slotSize*slot
+			hash, offset, err := readTuple(cdb.reader, slotOffset)
+			if err != nil {
+				return fmt.Errorf("%w: reading slot at offset %d: %s", ErrCorrupt, slotOffset, err)
+			}
+
+			if offset == 0 {
+				continue
+			} else if tableFor(hash, cdb.tableBits) != uint64(i) {
+				return fmt.Errorf("%w: slot at offset %d is in the wrong hash table", ErrCorrupt, slotOffset)
+			} else if !records[offset] {
+				return fmt.Errorf("%w: slot at offset %d points to offset %d, which isn't a record", ErrCorrupt, slotOffset, offset)
+			}
+		}
+	}
+
+	return nil
+}
+
+// slotPointsTo probes the hash table for hash, and reports whether any slot
+// along the way points to the record at offset, with the right check word.
+func (cdb *CDB) slotPointsTo(hash, check, offset uint64) (bool, error) {
+	table := cdb.header[tableFor(hash, cdb.tableBits)]
+	if table.length == 0 {
+		return false, nil
+	}
+
+	startingSlot := firstSlot(hash, cdb.tableBits, table.length)
+	slot := startingSlot
+	for {
+		slotOffset := table.offset + cdb.slotSize*slot
+		slotHash, slotRecord, slotCheck, err := cdb.readSlot(slotOffset)
+		if err != nil {
+			return false, fmt.Errorf("%w: reading slot at offset %d: %s", ErrCorrupt, slotOffset, err)
+		}
+
+		if slotRecord == 0 {
+			return false, nil
+		} else if slotHash == hash && slotCheck == check && slotRecord == offset {
+			return true, nil
+		}
+
+		slot = (slot + 1) % table.length
+		if slot == startingSlot {
+			return false, nil
+		}
+	}
+}
+
+// checkRecord checks the checksum at the end of a stored value, and returns
+// the value without it.
+func checkRecord(key, data []byte) ([]byte, error) {
+	if len(data) < crc32.Size {
+		return nil, fmt.Errorf("%w: key %q: value is too short for its checksum", ErrRecordCorrupt, key)
+	}
+
+	value, stored := data[:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	if crc32.Checksum(value, castagnoli) != binary.LittleEndian.Uint32(stored) {
+		return nil, fmt.Errorf("%w: key %q: checksum mismatch", ErrRecordCorrupt, key)
+	}
+
+	return value, nil
+}
+
+// VerifyChecksum recomputes the CRC32C of the data section, and compares it to
+// the one stored when the database was written. It's much cheaper than Verify,
+// since it reads the data section sequentially and skips the hash tables, but
+// it requires the database to have been written with the Checksum option;
+// otherwise it returns ErrNoChecksum.
+func (cdb *CDB) VerifyChecksum() error {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	s, ok := cdb.sections[sectionChecksum]
+	if cdb.flags&flagChecksum == 0 || !ok || s.length != crc32.Size {
+		return ErrNoChecksum
+	}
+
+	expected := make([]byte, crc32.Size)
+	_, err := cdb.reader.ReadAt(expected, int64(s.offset))
+	if err != nil {
+		return err
+	}
+
+	dataEnd := cdb.header[0].offset
+	data := io.NewSectionReader(cdb.reader, int64(cdb.dataStart), int64(dataEnd-cdb.dataStart))
+	checksum := crc32.New(castagnoli)
+	_, err = io.Copy(checksum, data)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(checksum.Sum(nil), expected) {
+		return fmt.Errorf("%w: checksum mismatch in data section", ErrCorrupt)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package cdb64
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestDB(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestVerify(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+
+	assert.NoError(t, db.Verify())
+}
+
+func TestVerifyDetectsCorruption(t *testing.T) {
+	cases := map[string]func(data []byte) []byte{
+		"flipped key byte": func(data []byte) []byte {
+			// The first record is foo/bar, so its key starts right after
+			// the length tuple.
+			data[headerSize+16] ^= 0xff
+			return data
+		},
+		"oversized value length": func(data []byte) []byte {
+			data[headerSize+8] = 0xff
+			return data
+		},
+		"truncated hash tables": func(data []byte) []byte {
+			return data[:len(data)-16]
+		},
+		"bad slot offset": func(data []byte) []byte {
+			// Point the last occupied slot somewhere that isn't a record.
+			for i := len(data) - 16; i > 0; i -= 16 {
+				if !bytes.Equal(data[i:i+8], make([]byte, 8)) {
+					data[i+8] = 1
+					break
+				}
+			}
+			return data
+		},
+	}
+
+	for name, corrupt := range cases {
+		// Hide the size of the reader, so that truncation isn't caught by
+		// New already.
+		r := struct{ io.ReaderAt }{bytes.NewReader(corrupt(readTestDB(t)))}
+		db, err := New(r, nil)
+		require.NoError(t, err, name)
+
+		err = db.Verify()
+		assert.ErrorIs(t, err, ErrCorrupt, name)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Checksum: true})
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		require.NoError(t, writer.Put(record[0], record[1]))
+	}
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+	assert.NoError(t, db.VerifyChecksum())
+	assert.NoError(t, db.Verify())
+
+	value, err := db.Get([]byte("crystal"))
+	require.NoError(t, err)
+	assert.Equal(t, "CASTLES", string(value))
+
+	// Flip a byte in the first value.
+	data, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	data[db.dataStart+16+3] ^= 0xff
+
+	db, err = New(bytes.NewReader(data), nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, db.VerifyChecksum(), ErrCorrupt)
+}
+
+func TestVerifyChecksumMissing(t *testing.T) {
+	db, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrNoChecksum, db.VerifyChecksum())
+}
+
+func TestRecordChecksums(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{RecordChecksums: true})
+	require.NoError(t, err)
+
+	testWritesReadable(t, writer)
+
+	f, err = ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err = NewWriterWithOptions(f, WriterOptions{RecordChecksums: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Put([]byte("empty"), []byte{}))
+	require.NoError(t, writer.Close())
+
+	data, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+
+	db, err := FromBytes(data, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Verify())
+
+	value, err := db.Get([]byte("empty"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{}, value)
+
+	r, n, err := db.GetReader([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	read, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(read))
+
+	// Flip a bit in the value of foo, which is the first record.
+	data[db.dataStart+16+3] ^= 1
+	db, err = FromBytes(data, nil)
+	require.NoError(t, err)
+
+	_, err = db.Get([]byte("foo"))
+	assert.ErrorIs(t, err, ErrRecordCorrupt)
+	assert.Contains(t, err.Error(), `"foo"`)
+
+	_, _, err = db.GetInto([]byte("foo"), make([]byte, 10))
+	assert.ErrorIs(t, err, ErrRecordCorrupt)
+
+	iter := db.Iter()
+	for iter.Next() {
+	}
+	assert.ErrorIs(t, iter.Err(), ErrRecordCorrupt)
+
+	assert.ErrorIs(t, db.Verify(), ErrCorrupt)
+
+	value, err = db.Get([]byte("empty"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{}, value)
+}
@@ -0,0 +1,47 @@
+package cdb64
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// Warm reads the hash tables, and whatever follows them, such as the bloom
+// filter, so that the OS has them cached before the first lookups need them.
+// Those are the parts of the file every lookup reads, so warming them takes
+// most of the latency out of the first lookups after opening a large
+// database; call it right after Open. Use WarmAll to warm the records too.
+//
+// On Linux, databases opened with OpenMmap ask the OS to read the mapping
+// ahead with madvise(MADV_WILLNEED), which returns without waiting for the
+// reads to finish; elsewhere, every page of the mapping is touched. Other
+// readers are read from start to end, so Warm returns once they're cached.
+// Databases already held in memory, such as with FromBytes, have nothing to
+// warm.
+func (cdb *CDB) Warm() error {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return cdb.warm(cdb.header[0].offset, cdb.end)
+}
+
+// WarmAll is like Warm, but reads the whole file, records and all.
+func (cdb *CDB) WarmAll() error {
+	cdb.mu.RLock()
+	defer cdb.mu.RUnlock()
+
+	return cdb.warm(0, cdb.end)
+}
+
+// warm reads the file from start to end into the OS cache.
+func (cdb *CDB) warm(start, end uint64) error {
+	switch r := cdb.reader.(type) {
+	case *mmapReader:
+		return willNeed(r.data, start, end)
+	case byteSlicer:
+		return nil
+	}
+
+	section := io.NewSectionReader(cdb.reader, int64(start), int64(end-start))
+	_, err := io.CopyBuffer(ioutil.Discard, section, make([]byte, 1<<20))
+	return err
+}
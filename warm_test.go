@@ -0,0 +1,36 @@
+package cdb64
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarm(t *testing.T) {
+	data, err := ioutil.ReadFile("./test/test.cdb")
+	require.NoError(t, err)
+
+	opened, err := Open("./test/test.cdb")
+	require.NoError(t, err)
+	defer opened.Close()
+
+	mapped, err := OpenMmap("./test/test.cdb")
+	require.NoError(t, err)
+	defer mapped.Close()
+
+	inMemory, err := FromBytes(data, nil)
+	require.NoError(t, err)
+
+	for _, db := range []*CDB{opened, mapped, inMemory} {
+		require.NoError(t, db.Warm())
+		require.NoError(t, db.WarmAll())
+
+		for _, record := range expectedRecords {
+			value, err := db.Get(record[0])
+			require.NoError(t, err)
+			assert.Equal(t, record[1], value)
+		}
+	}
+}
@@ -5,31 +5,272 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"runtime"
 	"sync"
 )
 
-var ErrTooMuchData = errors.New("CDB files are limited to 4GB of data")
+const defaultBufferSize = 65536
+
+// ErrTooMuchData is returned by Put if adding the record would push the file
+// past 2^63 bytes, the largest offset an io.ReaderAt can address.
+var ErrTooMuchData = errors.New("CDB files are limited to 8EB of data")
+
+// ErrDuplicateKey is returned (wrapped with the key) by Put when a key is
+// written twice to a Writer with WriterOptions.RejectDuplicates.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// ErrClosed is returned by Put, Delete, and the other methods that add
+// records, once the Writer has been finalized with Close or Freeze.
+var ErrClosed = errors.New("writer is closed")
+
+// ErrNilWriter is returned by NewWriter and the functions built on it when
+// they're given a nil writer.
+var ErrNilWriter = errors.New("cdb64: nil writer")
 
 // Writer provides an API for creating a CDB database record by record.
 //
 // Close or Freeze must be called to finalize the database, or the resulting
-// file will be invalid.
+// file will be invalid. A Writer isn't safe for concurrent use.
 type Writer struct {
 	hasher       HashFunc
+	hashID       uint32
+	keyHasher    hash.Hash64
+	options      WriterOptions
 	writer       io.WriteSeeker
-	entries      [256][]entry
+	entries      [][]entry
+	tableBits    uint
 	finalizeOnce sync.Once
 
+	// finalized is set once Close or Freeze has started finalizing, after
+	// which no more records can be added. closed is set once the underlying
+	// writer has been closed, or handed over to the CDB returned by Freeze,
+	// so that calling Close again doesn't close it twice. finalizeErr is the
+	// error finalizing returned, which Close and Freeze keep reporting.
+	finalized   bool
+	closed      bool
+	finalizeErr error
+
+	// memEntries is the number of entries held in memory, and spill holds
+	// the rest, for WriterOptions.MaxMemory.
+	memEntries int
+	spill      *spillFile
+
+	// dead holds the records that no entry points to any more, removed with
+	// Delete or padded out by a failed PutStream, so that finalizing can
+	// drop them.
+	dead []deadRecord
+
 	bufferedWriter      *bufio.Writer
 	bufferedOffset      int64
 	estimatedFooterSize int64
+
+	// buffer backs bufferedWriter. Unlike bufferedWriter, it's kept after
+	// finalizing, so that Reset can reuse it.
+	buffer *bufio.Writer
+
+	// dataWriter is where records are written. It's the buffered writer,
+	// possibly teed into a running checksum.
+	dataWriter io.Writer
+	checksum   hash.Hash32
+
+	// For stream writers, the finished database is copied from the temporary
+	// file to stream, block-compressed if compressBlockSize is set.
+	stream            io.Writer
+	tempFile          *os.File
+	compressBlockSize int
+
+	// For atomic writers, the finished database is renamed over atomicPath.
+	atomicPath string
+
+	// hashes holds the hash of every key written so far, for
+	// WriterOptions.RejectDuplicates.
+	hashes map[uint64]struct{}
+
+	// shard is set for the shards of a ShardedWriter, and stored in the
+	// trailer.
+	shard *shardID
 }
 
 type entry struct {
 	hash   uint64
 	offset uint64
+
+	// check is the key's checkHash, for WriterOptions.Hash128.
+	check uint64
+}
+
+// deadRecord is the location of a record in the data section that can't be
+// found.
+type deadRecord struct {
+	offset int64
+	size   int64
+}
+
+// WriterOptions configures a Writer created with NewWriterWithOptions.
+type WriterOptions struct {
+	// Hasher is the hash function used to place keys in the hash tables. If
+	// nil, it defaults to the CDB hash function. Versioned files record which
+	// of the hash functions in this package they were written with, so that
+	// readers select it automatically; NewXXHash implies Versioned, so that
+	// it's always recorded.
+	Hasher HashFunc
+
+	// BufferSize is the size of the buffer records are written through. If
+	// zero, it defaults to 64KB.
+	BufferSize int
+
+	// Versioned writes a magic number and format version at the start of the
+	// file, so that readers can tell it's a cdb64 database, and which format
+	// it was written in. Files written without it are still readable, but
+	// can't be told apart from arbitrary data.
+	Versioned bool
+
+	// Checksum computes a CRC32C of the data section as records are written,
+	// and stores it at the end of the file for CDB.VerifyChecksum. It implies
+	// Versioned.
+	Checksum bool
+
+	// BloomFalsePositiveRate, if non-zero, builds a bloom filter of all the
+	// keys when the database is finalized, targeting the given rate of false
+	// positives, such as 0.01. Readers load the filter into memory when the
+	// database is opened, and use it to reject most missing keys without
+	// reading the hash tables at all, which speeds up workloads dominated by
+	// lookups for keys that aren't there. The filter takes about 1.44 *
+	// log2(1/rate) bits per record, or 1.2 bytes per record at 1%, both on
+	// disk and in the reader's memory. It implies Versioned.
+	BloomFalsePositiveRate float64
+
+	// Codec, if set, encodes every value before it's written, for example to
+	// compress it with GzipCodec. Keys are left as they are. The codec's ID is
+	// stored in the header, and readers decode values automatically. It
+	// implies Versioned.
+	Codec ValueCodec
+
+	// SortData rewrites the records in order of their keys when the database
+	// is finalized, which groups similar keys together, so that the file
+	// compresses better, with gzip for example. Records with the same key
+	// keep their relative order, and records removed with Delete are dropped
+	// for good. It's expensive: finalizing has to copy the whole data section
+	// to a temporary file in TempDir and back, and read every key into
+	// memory. The writer must also be an io.ReaderAt, as an *os.File is.
+	SortData bool
+
+	// RecordChecksums stores a CRC32C after every value, which is checked
+	// whenever the value is read, so that a corrupt record is reported with
+	// an error wrapping ErrRecordCorrupt rather than returned as it is. It
+	// takes 4 bytes per record. Keys aren't covered, since a damaged key
+	// just can't be found. It implies Versioned.
+	RecordChecksums bool
+
+	// Expiry stores an expiry time with every record, taking 8 bytes each.
+	// It's set with PutWithExpiry, and records added with Put never expire.
+	// Readers ignore it, except for CDB.GetFresh, which treats expired
+	// records as missing. It implies Versioned.
+	Expiry bool
+
+	// SortedKeys writes an index of the records sorted by key when the
+	// database is finalized, which makes CDB.ScanPrefix possible. The index
+	// takes 8 bytes per record on disk. Building it reads all the keys back
+	// into memory at once, so the writer must also be an io.ReaderAt, as an
+	// *os.File is. It implies Versioned.
+	SortedKeys bool
+
+	// TableCount is the number of hash tables keys are spread across, which
+	// must be a power of two. If zero, it defaults to 256, the only count
+	// legacy files can have. More tables keep each one smaller for very
+	// large databases, and fewer save space in the header of tiny ones,
+	// which takes 16 bytes per table. Any other count implies Versioned.
+	TableCount int
+
+	// ExpectedRecords, if TableCount is zero, sizes the table count for
+	// about that many records, using SuggestTableCount. It's only a hint:
+	// any number of records can still be written.
+	ExpectedRecords int
+
+	// OnFinalizeProgress, if set, is called from Close or Freeze after each of
+	// the hash tables has been written, so that long-running builds can
+	// report progress. It's never called after Close or Freeze returns.
+	OnFinalizeProgress func(tablesDone, totalTables int)
+
+	// PreallocateSize, if non-zero, reserves that many bytes of disk space
+	// for the database up front, which avoids fragmentation and repeated
+	// allocation when bulk loading large databases. It should be roughly the
+	// expected size of the finished file. It only applies when writing to an
+	// *os.File, on systems and filesystems that support it; elsewhere, it
+	// has no effect. The file's size isn't changed.
+	PreallocateSize int64
+
+	// Sync makes Close and Freeze flush the finished database to stable
+	// storage before returning, so that it survives a crash right after. It
+	// applies if the underlying writer has a Sync method, as *os.File does;
+	// otherwise it has no effect.
+	Sync bool
+
+	// RejectDuplicates makes Put return an error wrapping ErrDuplicateKey if
+	// the key has already been written, rather than adding a second record.
+	// It keeps the hash of every key in memory, which takes roughly 40 bytes
+	// per record on top of the usual 24. When two keys' hashes collide, the
+	// earlier key is read back to compare it, if the writer is also an
+	// io.ReaderAt; if it isn't, a collision is reported as a duplicate.
+	RejectDuplicates bool
+
+	// HeaderLast writes the index of hash tables at the end of the file,
+	// rather than going back to write it at the start once the database is
+	// finalized, so the file is written in a single forward pass. That makes
+	// it possible to write to media that only support appending, and lets
+	// NewStreamWriterWithOptions write straight to the stream, without a
+	// temporary file. The writer must be at the start of the file, and is
+	// never seeked, unless SortData is set or records have been deleted, so
+	// the records after them have to be moved. Readers need to know the size of
+	// the file to find the index; see NewWithSize. It implies Versioned.
+	HeaderLast bool
+
+	// Hash128 stores a second, independent 64-bit hash of every key in its
+	// hash table slot, next to the usual one, so that slots effectively hold
+	// a 128-bit hash. Lookups compare both before reading a stored key, so
+	// they almost never read the key of a record that turns out to be the
+	// wrong one: with 64-bit hashes, a database of a billion records has
+	// around a 1 in 20 chance of holding any two keys with the same hash,
+	// and a lookup for a missing key matches a stranger's hash about once in
+	// every 10^10 probes, while with 128 bits both are negligible. It's
+	// mostly worth it for huge databases, or ones using the CDB hash
+	// function, which collides far more often than a good 64-bit hash. It
+	// costs 8 more bytes per slot, or about 16 bytes per record, on disk, and
+	// a little time hashing each key twice. It implies Versioned.
+	Hash128 bool
+
+	// MaxMemory, if non-zero, bounds the memory used to hold the hash table
+	// entries for records as they're written, which is otherwise 24 bytes
+	// per record. Whenever the entries in memory reach MaxMemory bytes,
+	// they're moved to a temporary file in TempDir, and they're read back one
+	// table at a time when the database is finalized. That makes it possible
+	// to build databases with far more records than would fit in memory, at
+	// the cost of the extra I/O; finalizing still needs about 72 bytes per
+	// record in each of the few hash tables being built at once. Delete and
+	// RejectDuplicates have to read the moved entries back, which makes them
+	// much slower, and SortData and SortedKeys hold every key in memory
+	// regardless, so SortData can't be combined with it.
+	MaxMemory int64
+
+	// TempDir is the directory used for temporary files, such as the one
+	// backing a stream writer. If empty, it defaults to os.TempDir.
+	TempDir string
+
+	// OnPut, if set, is called with every record added by Put, or by the
+	// methods built on it such as PutAll and PutWithExpiry, once it's been
+	// written successfully; it's not called if the write fails. The value is
+	// the one that was passed in, before any encoding. It's meant for
+	// logging or counting records as they go in, and must not keep key or
+	// value, or modify them. Records copied with CopyFrom don't call it, and
+	// neither do ones added with PutStream, unless a Codec makes it read the
+	// value into memory and pass it to Put.
+	OnPut func(key, value []byte)
 }
 
 // Create opens a CDB database at the given path. If the file exists, it will
@@ -43,82 +284,709 @@ func Create(path string) (*Writer, error) {
 	return NewWriter(f, nil)
 }
 
+// CreateWithOptions is like Create, but configures the Writer with opts.
+func CreateWithOptions(path string, opts WriterOptions) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cdb, err := NewWriterWithOptions(f, opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return cdb, nil
+}
+
+// SuggestTableCount returns a table count for WriterOptions.TableCount that
+// suits a database of numRecords records: the smallest power of two that
+// keeps the average hash table to 128 records or fewer, so that for larger
+// databases, tables hold between 64 and 128 records each. Small tables keep
+// the slotting work in finalizing spread evenly, and give a lookup less to
+// read around its slot, while the header costs only 16 bytes per table.
+func SuggestTableCount(numRecords int) int {
+	tables := 1
+	for tables < maxTables && tables*128 < numRecords {
+		tables <<= 1
+	}
+
+	return tables
+}
+
 // NewWriter opens a CDB database for the given io.WriteSeeker.
 //
 // If hasher is nil, it will default to the CDB hash function.
 func NewWriter(writer io.WriteSeeker, hasher HashFunc) (*Writer, error) {
-	// Leave 256 * 8 * 2 bytes for the index at the head of the file.
-	_, err := writer.Seek(0, os.SEEK_SET)
+	return NewWriterWithOptions(writer, WriterOptions{Hasher: hasher})
+}
+
+// NewWriterWithOptions opens a CDB database for the given io.WriteSeeker,
+// configured by opts.
+func NewWriterWithOptions(writer io.WriteSeeker, opts WriterOptions) (*Writer, error) {
+	if writer == nil {
+		return nil, ErrNilWriter
+	}
+
+	if opts.TableCount != 0 {
+		if _, err := tableBits(opts.TableCount); err != nil {
+			return nil, err
+		}
+	}
+
+	cdb := newWriter(writer, opts)
+	err := cdb.start()
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = writer.Write(make([]byte, headerSize))
+	return cdb, nil
+}
+
+// start reserves space for the header at the start of the underlying writer,
+// and gets ready to write the first record after it.
+func (cdb *Writer) start() error {
+	if _, ok := cdb.writer.(io.ReaderAt); cdb.options.SortedKeys && !ok {
+		return errors.New("SortedKeys requires a writer that is also an io.ReaderAt")
+	} else if cdb.options.SortData && !ok {
+		return errors.New("SortData requires a writer that is also an io.ReaderAt")
+	} else if cdb.options.SortData && cdb.options.MaxMemory > 0 {
+		return errors.New("SortData can't be combined with MaxMemory")
+	}
+
+	if f, ok := cdb.writer.(*os.File); ok && cdb.options.PreallocateSize > 0 {
+		err := preallocate(f, cdb.options.PreallocateSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	// With the header last, nothing is ever written back over the start of
+	// the file, so the preamble is written out in full up front, and the
+	// writer is never seeked.
+	if cdb.options.HeaderLast {
+		_, err := cdb.writer.Write(cdb.preamble().marshal())
+		if err != nil {
+			return err
+		}
+
+		cdb.startData(preambleSize)
+		return nil
+	}
+
+	// Leave 256 * 8 * 2 bytes for the index at the head of the file, plus
+	// room for the preamble if there is one.
+	_, err := cdb.writer.Seek(0, os.SEEK_SET)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	_, err = cdb.writer.Write(make([]byte, cdb.dataStart()))
+	if err != nil {
+		return err
+	}
+
+	cdb.startData(int64(cdb.dataStart()))
+	return nil
+}
+
+// newWriter sets up a Writer for opts, filling in defaults, without doing any
+// I/O. startData must be called before any records are written.
+func newWriter(writer io.WriteSeeker, opts WriterOptions) *Writer {
+	if opts.Hasher == nil {
+		opts.Hasher = newCDBHash
 	}
 
-	if hasher == nil {
-		hasher = newCDBHash
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
 	}
 
+	if opts.TableCount == 0 && opts.ExpectedRecords > 0 {
+		opts.TableCount = SuggestTableCount(opts.ExpectedRecords)
+	}
+
+	if opts.TableCount == 0 {
+		opts.TableCount = defaultTables
+	} else if opts.TableCount != defaultTables {
+		opts.Versioned = true
+	}
+
+	// Databases written with a hash function other than the default record it
+	// in the preamble, so that they can't be read with the wrong one.
+	hashID := identifyHash(opts.Hasher)
+	if hashID != hashUnknown && hashID != hashCDB {
+		opts.Versioned = true
+	}
+
+	if opts.Checksum || opts.BloomFalsePositiveRate > 0 || opts.Codec != nil || opts.SortedKeys || opts.RecordChecksums || opts.Expiry || opts.HeaderLast || opts.Hash128 {
+		opts.Versioned = true
+	}
+
+	bits, _ := tableBits(opts.TableCount)
 	return &Writer{
-		hasher:         hasher,
-		writer:         writer,
-		bufferedWriter: bufio.NewWriterSize(writer, 65536),
-		bufferedOffset: headerSize,
-	}, nil
+		hasher:    opts.Hasher,
+		hashID:    hashID,
+		keyHasher: opts.Hasher(),
+		options:   opts,
+		writer:    writer,
+		entries:   make([][]entry, opts.TableCount),
+		tableBits: bits,
+	}
+}
+
+// startData sets up buffering for records, the first of which will be written
+// at offset. The underlying writer must already be positioned there.
+func (cdb *Writer) startData(offset int64) {
+	if cdb.buffer == nil {
+		cdb.buffer = bufio.NewWriterSize(cdb.writer, cdb.options.BufferSize)
+	} else {
+		cdb.buffer.Reset(cdb.writer)
+	}
+
+	cdb.bufferedWriter = cdb.buffer
+	cdb.bufferedOffset = offset
+	cdb.dataWriter = cdb.bufferedWriter
+	if cdb.options.Checksum {
+		cdb.checksum = crc32.New(castagnoli)
+		cdb.dataWriter = io.MultiWriter(cdb.bufferedWriter, cdb.checksum)
+	}
+}
+
+// NewStreamWriter opens a CDB database that will be written to w, which
+// doesn't need to be seekable, such as a pipe or a network connection.
+//
+// Since the header can only be written once all the records are known, the
+// database is built in a temporary file first, which needs as much space as
+// the finished database. On Close, the finished database is copied to w in a
+// single forward pass, and the temporary file is removed. Freeze can't open a
+// stream for reads, so it returns os.ErrInvalid after finalizing; Close must
+// still be called to clean up.
+//
+// If hasher is nil, it will default to the CDB hash function.
+func NewStreamWriter(w io.Writer, hasher HashFunc) (*Writer, error) {
+	return NewStreamWriterWithOptions(w, WriterOptions{Hasher: hasher})
+}
+
+// NewStreamWriterWithOptions is like NewStreamWriter, but configured by opts.
+// The temporary file is created in opts.TempDir. With opts.HeaderLast, there's
+// no need for one: the database is written straight to w as records are added.
+func NewStreamWriterWithOptions(w io.Writer, opts WriterOptions) (*Writer, error) {
+	if w == nil {
+		return nil, ErrNilWriter
+	}
+
+	if opts.HeaderLast {
+		return NewWriterWithOptions(&forwardWriter{w}, opts)
+	}
+
+	f, err := ioutil.TempFile(opts.TempDir, "cdb64-stream")
+	if err != nil {
+		return nil, err
+	}
+
+	cdb, err := NewWriterWithOptions(f, opts)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	cdb.stream = w
+	cdb.tempFile = f
+	return cdb, nil
+}
+
+// forwardWriter adapts a stream to io.WriteSeeker, for HeaderLast writers,
+// which never seek.
+type forwardWriter struct {
+	io.Writer
+}
+
+func (w *forwardWriter) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("stream writers can't seek")
+}
+
+func (w *forwardWriter) Sync() error {
+	if syncer, ok := w.Writer.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+
+	return nil
+}
+
+func (w *forwardWriter) Close() error {
+	if closer, ok := w.Writer.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// KV is a key/value pair, for use with PutAll.
+type KV struct {
+	Key, Value []byte
 }
 
 // Put adds a key/value pair to the database.
 func (cdb *Writer) Put(key, value []byte) error {
-	if key == nil || value == nil {
+	return cdb.put(key, value, 0)
+}
+
+// put adds a record which expires at the given unix time, if the database
+// stores expiry times.
+func (cdb *Writer) put(key, value []byte, expires int64) error {
+	if cdb.finalized {
+		return ErrClosed
+	} else if key == nil || value == nil {
 		return fmt.Errorf("key or value can not be nil.")
 	}
-	entrySize := int64(16 + len(key) + len(value))
+
+	stored, recordChecksum := cdb.encodeValue(value, expires)
+	err := cdb.writeRecord(key, cdb.hashKey(key), stored, recordChecksum)
+	if err != nil {
+		return err
+	}
+
+	if cdb.options.OnPut != nil {
+		cdb.options.OnPut(key, value)
+	}
+
+	return nil
+}
+
+// encodeValue returns value as it's stored, encoded with the codec and
+// followed by its expiry time, if the Writer has those options, and its
+// record checksum, or nil if it doesn't.
+func (cdb *Writer) encodeValue(value []byte, expires int64) ([]byte, []byte) {
+	if cdb.options.Codec != nil {
+		value = cdb.options.Codec.Encode(value)
+	}
+
+	if cdb.options.Expiry {
+		value = appendExpiry(value, expires)
+	}
+
+	var recordChecksum []byte
+	if cdb.options.RecordChecksums {
+		recordChecksum = make([]byte, crc32.Size)
+		binary.LittleEndian.PutUint32(recordChecksum, crc32.Checksum(value, castagnoli))
+	}
+
+	return value, recordChecksum
+}
+
+// writeRecord writes a record whose key has the given hash, with a value
+// that's already encoded, followed by its checksum, if any.
+func (cdb *Writer) writeRecord(key []byte, hash uint64, value, recordChecksum []byte) error {
+	entrySize := int64(16 + len(key) + len(value) + len(recordChecksum))
+
+	// Offsets are 64 bits throughout, but leave room for the hash tables to
+	// fit below the largest offset ReadAt accepts.
+	footerSize := cdb.estimatedFooterSize + 2*cdb.slotSize()
+	if cdb.bufferedOffset > math.MaxInt64-entrySize-footerSize {
+		return ErrTooMuchData
+	}
+
+	if cdb.options.RejectDuplicates {
+		err := cdb.checkDuplicate(key, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry := entry{hash: hash, offset: uint64(cdb.bufferedOffset)}
+	if cdb.options.Hash128 {
+		entry.check = checkHash(key)
+	}
+
+	// Write the key length, then value length, then key, then value.
+	err := writeTuple(cdb.dataWriter, uint64(len(key)), uint64(len(value)+len(recordChecksum)))
+	if err != nil {
+		return err
+	}
+
+	_, err = cdb.dataWriter.Write(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = cdb.dataWriter.Write(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = cdb.dataWriter.Write(recordChecksum)
+	if err != nil {
+		return err
+	}
+
+	cdb.bufferedOffset += entrySize
+	cdb.estimatedFooterSize += 2 * cdb.slotSize()
 
 	// Record the entry in the hash table, to be written out at the end.
-	hasher := cdb.hasher()
+	return cdb.addEntry(tableFor(hash, cdb.tableBits), entry)
+}
+
+// hashKey hashes key. A Writer isn't safe for concurrent use, so the hasher
+// can be reused.
+func (cdb *Writer) hashKey(key []byte) uint64 {
+	hasher := cdb.keyHasher
 	hasher.Reset()
 	hasher.Write(key)
-	hash := hasher.Sum64()
-	table := hash & 0xff
 
-	entry := entry{hash: hash, offset: uint64(cdb.bufferedOffset)}
-	cdb.entries[table] = append(cdb.entries[table], entry)
+	return hasher.Sum64()
+}
 
-	// Write the key length, then value length, then key, then value.
-	err := writeTuple(cdb.bufferedWriter, uint64(len(key)), uint64(len(value)))
+// checkDuplicate returns an error wrapping ErrDuplicateKey if key, with the
+// given hash, has already been written, and otherwise remembers it.
+func (cdb *Writer) checkDuplicate(key []byte, hash uint64) error {
+	if cdb.hashes == nil {
+		cdb.hashes = make(map[uint64]struct{})
+	}
+
+	if _, ok := cdb.hashes[hash]; !ok {
+		cdb.hashes[hash] = struct{}{}
+		return nil
+	}
+
+	// The hash has been seen before, but that might have been for another
+	// key, or for a record since deleted.
+	readerAt, canRead := cdb.writer.(io.ReaderAt)
+	if canRead {
+		err := cdb.bufferedWriter.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, err := cdb.tableEntries(int(tableFor(hash, cdb.tableBits)))
 	if err != nil {
 		return err
 	}
 
-	_, err = cdb.bufferedWriter.Write(key)
+	for _, entry := range entries {
+		if entry.hash != hash {
+			continue
+		}
+
+		match := true
+		if canRead {
+			var err error
+			_, match, err = matchKeyAt(readerAt, entry.offset, key, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		if match {
+			return fmt.Errorf("%w: %q", ErrDuplicateKey, key)
+		}
+	}
+
+	return nil
+}
+
+// EstimatedSize returns roughly how large the database would be if it were
+// finalized now: the records written so far, plus two hash table slots per
+// record. It's meant for deciding when to start a new file to stay under a
+// size budget. It's only an estimate, since the exact layout of the hash
+// tables is only known when the database is finalized, and it doesn't include
+// optional extras like a bloom filter or a sorted key index.
+func (cdb *Writer) EstimatedSize() int64 {
+	size := cdb.bufferedOffset + cdb.estimatedFooterSize
+	if cdb.options.HeaderLast {
+		size += int64(len(cdb.entries))*16 + 16
+	}
+
+	return size
+}
+
+// NumRecords returns the number of records in the database: every record
+// added so far, less any removed with Delete. It stays valid after Close or
+// Freeze, when it's the number of records in the finished database.
+func (cdb *Writer) NumRecords() int {
+	n := cdb.memEntries
+	if cdb.spill != nil {
+		n += cdb.spill.count
+	}
+
+	return n
+}
+
+// Reset discards everything written so far, and starts a new database on w,
+// with the same options, as if the Writer had just been returned by
+// NewWriterWithOptions. The memory held for hash table entries and for the
+// write buffer is kept and reused, which saves garbage when one Writer builds
+// many databases in turn.
+//
+// Reset doesn't finalize or close the previous database; call Close first to
+// keep it. A stream writer becomes a regular writer for w, and its temporary
+// file is removed if Close hasn't already done so.
+func (cdb *Writer) Reset(w io.WriteSeeker) error {
+	if cdb.tempFile != nil {
+		cdb.tempFile.Close()
+		os.Remove(cdb.tempFile.Name())
+	}
+
+	cdb.stream = nil
+	cdb.tempFile = nil
+	cdb.compressBlockSize = 0
+	cdb.atomicPath = ""
+	cdb.writer = w
+	cdb.finalizeOnce = sync.Once{}
+	cdb.finalized = false
+	cdb.closed = false
+	cdb.finalizeErr = nil
+	cdb.estimatedFooterSize = 0
+	cdb.checksum = nil
+	cdb.hashes = nil
+	cdb.removeSpill()
+	cdb.memEntries = 0
+	cdb.dead = cdb.dead[:0]
+	for i := range cdb.entries {
+		cdb.entries[i] = cdb.entries[i][:0]
+	}
+
+	return cdb.start()
+}
+
+// PutString is like Put, but takes the key as a string.
+func (cdb *Writer) PutString(key string, value []byte) error {
+	return cdb.Put(stringBytes(key), value)
+}
+
+// PutUint64 is like Put, but stores v as an 8-byte little-endian value. It can
+// be read back with CDB.GetUint64.
+func (cdb *Writer) PutUint64(key []byte, v uint64) error {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, v)
+
+	return cdb.Put(key, value)
+}
+
+// PutStream is like Put, but copies the value from r, which must supply
+// exactly valueLength bytes, straight into the database, so that large values
+// never have to be held in memory. If r ends early, PutStream returns
+// io.ErrUnexpectedEOF, and if reading from it fails, it returns that error;
+// either way, the part of the record already written is padded out and left
+// as dead space, which is dropped when the database is finalized, as with
+// Delete, so the Writer can still be used. That needs the underlying writer
+// to be an io.ReaderAt, though; if it isn't, the Writer is stopped as if
+// finalizing had failed: later calls return ErrClosed, and Close returns the
+// error.
+//
+// With a ValueCodec, values have to be encoded whole, so the value is read
+// into memory after all.
+func (cdb *Writer) PutStream(key []byte, r io.Reader, valueLength int64) error {
+	if cdb.finalized {
+		return ErrClosed
+	} else if key == nil || r == nil || valueLength < 0 {
+		return fmt.Errorf("key or value can not be nil.")
+	}
+
+	if cdb.options.Codec != nil {
+		value := make([]byte, valueLength)
+		_, err := io.ReadFull(r, value)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return cdb.Put(key, value)
+	}
+
+	var extra int64
+	if cdb.options.Expiry {
+		extra += 8
+	}
+
+	if cdb.options.RecordChecksums {
+		extra += crc32.Size
+	}
+
+	// Check the lengths before adding them up, so the sums can't overflow.
+	if valueLength > math.MaxInt64-16-int64(len(key))-extra {
+		return ErrTooMuchData
+	}
+
+	storedLength := valueLength + extra
+	entrySize := 16 + int64(len(key)) + storedLength
+	footerSize := cdb.estimatedFooterSize + 2*cdb.slotSize()
+	if cdb.bufferedOffset > math.MaxInt64-entrySize-footerSize {
+		return ErrTooMuchData
+	}
+
+	keyHash := cdb.hashKey(key)
+	if cdb.options.RejectDuplicates {
+		err := cdb.checkDuplicate(key, keyHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := writeTuple(cdb.dataWriter, uint64(len(key)), uint64(storedLength))
+	if err != nil {
+		return err
+	}
+
+	_, err = cdb.dataWriter.Write(key)
 	if err != nil {
 		return err
 	}
 
-	_, err = cdb.bufferedWriter.Write(value)
+	// The record checksum covers the value, and the expiry time if there is
+	// one.
+	w := cdb.dataWriter
+	var checksum hash.Hash32
+	if cdb.options.RecordChecksums {
+		checksum = crc32.New(castagnoli)
+		w = io.MultiWriter(cdb.dataWriter, checksum)
+	}
+
+	n, err := io.CopyN(w, r, valueLength)
 	if err != nil {
+		// Pad the record out to the length already written, so that the
+		// records after it are still where they should be. If writing
+		// failed, rather than reading, the buffered writer keeps failing,
+		// so nothing more can be written anyway.
+		_, padErr := cdb.dataWriter.Write(make([]byte, storedLength-n))
+		if padErr != nil {
+			return padErr
+		}
+
+		cdb.dead = append(cdb.dead, deadRecord{offset: cdb.bufferedOffset, size: entrySize})
+		cdb.bufferedOffset += entrySize
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if _, ok := cdb.writer.(io.ReaderAt); !ok {
+			cdb.fail(err)
+		}
+
 		return err
 	}
 
+	if cdb.options.Expiry {
+		_, err = w.Write(make([]byte, 8))
+		if err != nil {
+			return err
+		}
+	}
+
+	if checksum != nil {
+		recordChecksum := make([]byte, crc32.Size)
+		binary.LittleEndian.PutUint32(recordChecksum, checksum.Sum32())
+		_, err = cdb.dataWriter.Write(recordChecksum)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry := entry{hash: keyHash, offset: uint64(cdb.bufferedOffset)}
+	if cdb.options.Hash128 {
+		entry.check = checkHash(key)
+	}
+
 	cdb.bufferedOffset += entrySize
-	cdb.estimatedFooterSize += 32
+	cdb.estimatedFooterSize += 2 * cdb.slotSize()
+	return cdb.addEntry(tableFor(keyHash, cdb.tableBits), entry)
+}
+
+// Delete removes every record for key that has been added so far, so that
+// the key can't be found in the finished database. Records for key added
+// after Delete are kept as usual. It must be called before Close or Freeze.
+//
+// To tell the key apart from other keys with the same hash, the records are
+// read back, so the underlying writer must also be an io.ReaderAt, as an
+// *os.File is. The deleted records stay in the file until the database is
+// finalized, when the records after them are moved down over them, so the
+// finished database holds no trace of them. That rewrites the data section
+// from the first deleted record on.
+func (cdb *Writer) Delete(key []byte) error {
+	if cdb.finalized {
+		return ErrClosed
+	}
+
+	readerAt, ok := cdb.writer.(io.ReaderAt)
+	if !ok {
+		return errors.New("Delete requires a writer that is also an io.ReaderAt")
+	}
+
+	// Make sure the records can be read back.
+	err := cdb.bufferedWriter.Flush()
+	if err != nil {
+		return err
+	}
+
+	hash := cdb.hashKey(key)
+	var dead []deadRecord
+	dropped, err := cdb.filterEntries(tableFor(hash, cdb.tableBits), func(entry entry) (bool, error) {
+		if entry.hash != hash {
+			return false, nil
+		}
+
+		valueLength, match, err := matchKeyAt(readerAt, entry.offset, key, nil)
+		if match {
+			size := 16 + int64(len(key)) + int64(valueLength)
+			dead = append(dead, deadRecord{offset: int64(entry.offset), size: size})
+		}
+
+		return match, err
+	})
+
+	cdb.estimatedFooterSize -= 2 * cdb.slotSize() * int64(dropped)
+	if err != nil {
+		return err
+	}
+
+	cdb.dead = append(cdb.dead, dead...)
+	return nil
+}
+
+// PutAll adds all the given key/value pairs to the database, in order. It's
+// equivalent to calling Put for each pair. If a pair can't be written, PutAll
+// stops and returns the error; the pairs before it will already have been
+// added.
+func (cdb *Writer) PutAll(pairs []KV) error {
+	for _, pair := range pairs {
+		err := cdb.Put(pair.Key, pair.Value)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// Close finalizes the database, then closes it to further writes.
+// Close finalizes the database, then closes it to further writes. Once it's
+// been called, Put returns ErrClosed, and calling Close again does nothing.
+// If finalizing fails, the underlying writer is closed all the same, and
+// Close returns the error, as does every later call to Close or Freeze.
 //
 // Close or Freeze must be called to finalize the database, or the resulting
 // file will be invalid.
 func (cdb *Writer) Close() error {
-	var err error
 	cdb.finalizeOnce.Do(func() {
-		_, err = cdb.finalize()
+		cdb.finalized = true
+		cdb.finalizeErr = cdb.finalize()
 	})
 
+	err := cdb.finalizeErr
+	if cdb.closed {
+		return err
+	} else if cdb.stream != nil {
+		cdb.closed = true
+		return cdb.closeStream(err)
+	}
+
+	cdb.closed = true
 	if err != nil {
+		cdb.abandon()
 		return err
 	}
 
@@ -135,84 +1003,405 @@ func (cdb *Writer) Close() error {
 // Close or Freeze must be called to finalize the database, or the resulting
 // file will be invalid.
 func (cdb *Writer) Freeze() (*CDB, error) {
-	var err error
-	var header Header
 	cdb.finalizeOnce.Do(func() {
-		header, err = cdb.finalize()
+		cdb.finalized = true
+		cdb.finalizeErr = cdb.finalize()
 	})
 
+	err := cdb.finalizeErr
 	if err != nil {
+		if !cdb.closed && cdb.stream == nil {
+			cdb.closed = true
+			cdb.abandon()
+		}
+
 		return nil, err
+	} else if cdb.stream != nil {
+		return nil, os.ErrInvalid
 	}
 
 	if readerAt, ok := cdb.writer.(io.ReaderAt); ok {
-		return &CDB{reader: readerAt, header: header, hasher: cdb.hasher}, nil
+		db, err := New(readerAt, cdb.hasher)
+		if err == nil {
+			// Closing the database closes the writer now.
+			cdb.closed = true
+		}
+
+		return db, err
 	} else {
 		return nil, os.ErrInvalid
 	}
 }
 
-func (cdb *Writer) finalize() (Header, error) {
-	var index Header
+// abandon closes the underlying writer after finalizing has failed, and
+// removes the temporary file of an atomic writer, so the destination is left
+// untouched.
+func (cdb *Writer) abandon() {
+	if cdb.atomicPath != "" {
+		cdb.abortAtomic()
+	} else if closer, ok := cdb.writer.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// fail stops the Writer after an error it can't recover from, as if
+// finalizing had returned err: no more records can be added, and Close and
+// Freeze return err.
+func (cdb *Writer) fail(err error) {
+	cdb.finalizeOnce.Do(func() {
+		cdb.finalized = true
+		cdb.finalizeErr = err
+		cdb.removeSpill()
+	})
+}
 
-	// Write the hashtables out, one by one, at the end of the file.
-	for i := 0; i < 256; i++ {
-		tableEntries := cdb.entries[i]
-		tableSize := uint64(len(tableEntries) << 1)
+func (cdb *Writer) finalize() error {
+	defer cdb.removeSpill()
 
-		index[i] = table{
-			offset: uint64(cdb.bufferedOffset),
-			length: tableSize,
+	// Sorting the records drops the dead ones along the way.
+	index := make([]table, len(cdb.entries))
+	if cdb.options.SortData {
+		err := cdb.sortData()
+		if err != nil {
+			return err
+		}
+	} else if len(cdb.dead) > 0 {
+		err := cdb.dropDead()
+		if err != nil {
+			return err
 		}
+	}
 
-		sorted := make([]entry, tableSize)
-		for _, entry := range tableEntries {
-			slot := (entry.hash >> 8) % tableSize
+	// Slotting entries into the hash tables is independent for each table, so
+	// it's spread across a pool of goroutines. The tables still have to be
+	// written out in order, so only a few are built ahead of the one being
+	// written, to bound the memory they hold.
+	tables := make([]chan []entry, len(cdb.entries))
+	errs := make([]error, len(cdb.entries))
+	for i := range tables {
+		tables[i] = make(chan []entry, 1)
+	}
 
-			for {
-				if sorted[slot].hash == 0 {
-					sorted[slot] = entry
-					break
-				}
+	ahead := make(chan struct{}, runtime.GOMAXPROCS(0))
+	go func() {
+		for i := range tables {
+			ahead <- struct{}{}
+			go func(i int) {
+				entries, err := cdb.tableEntries(i)
+				errs[i] = err
+				tables[i] <- slotEntries(entries, cdb.tableBits)
+			}(i)
+		}
+	}()
 
-				slot = (slot + 1) % tableSize
-			}
+	// Write the hashtables out, one by one, at the end of the file. On an
+	// error, keep receiving the rest so that no goroutines are left blocked.
+	var err error
+	for i := range tables {
+		sorted := <-tables[i]
+		<-ahead
+		if err == nil {
+			err = errs[i]
+		}
+
+		if err != nil {
+			continue
+		}
+
+		index[i] = table{
+			offset: uint64(cdb.bufferedOffset),
+			length: uint64(len(sorted)),
 		}
 
 		for _, entry := range sorted {
-			err := writeTuple(cdb.bufferedWriter, entry.hash, entry.offset)
+			err = writeTuple(cdb.bufferedWriter, entry.hash, entry.offset)
+			if err == nil && cdb.options.Hash128 {
+				err = writeUint64(cdb.bufferedWriter, entry.check)
+			}
+
 			if err != nil {
-				return index, err
+				break
 			}
 
-			cdb.bufferedOffset += 16
+			cdb.bufferedOffset += cdb.slotSize()
+		}
+
+		if err == nil && cdb.options.OnFinalizeProgress != nil {
+			cdb.options.OnFinalizeProgress(i+1, len(tables))
 		}
 	}
 
-	// We're done with the buffer.
-	err := cdb.bufferedWriter.Flush()
-	cdb.bufferedWriter = nil
 	if err != nil {
-		return index, err
+		return err
 	}
 
-	// Seek to the beginning of the file and write out the index.
-	_, err = cdb.writer.Seek(0, os.SEEK_SET)
-	if err != nil {
-		return index, err
+	// Write out any optional sections after the hash tables.
+	p := cdb.preamble()
+	sections := make(map[uint64][]byte)
+	if cdb.checksum != nil {
+		sections[sectionChecksum] = cdb.checksum.Sum(nil)
 	}
 
-	buf := make([]byte, headerSize)
+	if cdb.options.BloomFalsePositiveRate > 0 {
+		bloom, err := cdb.buildBloom()
+		if err != nil {
+			return err
+		}
+
+		sections[sectionBloom] = bloom.marshal()
+	}
+
+	if cdb.options.SortedKeys {
+		sections[sectionSortedKeys], err = cdb.buildSortedKeys()
+		if err != nil {
+			return err
+		}
+	}
+
+	if cdb.shard != nil {
+		sections[sectionShard] = cdb.shard.marshal()
+	}
+
+	if len(sections) > 0 {
+		p.trailer = uint64(cdb.bufferedOffset)
+		n, err := writeTrailer(cdb.bufferedWriter, sections)
+		if err != nil {
+			return err
+		}
+
+		cdb.bufferedOffset += n
+	}
+
+	buf := make([]byte, len(index)*16)
 	for i, table := range index {
 		off := i * 16
 		binary.LittleEndian.PutUint64(buf[off:off+8], table.offset)
 		binary.LittleEndian.PutUint64(buf[off+8:off+16], table.length)
 	}
 
-	_, err = cdb.writer.Write(buf)
+	// With the header last, the index goes at the very end, followed by a
+	// footer pointing back to it and to the trailer.
+	if cdb.options.HeaderLast {
+		indexOffset := cdb.bufferedOffset
+		_, err = cdb.bufferedWriter.Write(buf)
+		if err != nil {
+			return err
+		}
+
+		err = writeTuple(cdb.bufferedWriter, uint64(indexOffset), p.trailer)
+		if err != nil {
+			return err
+		}
+
+		cdb.bufferedOffset += int64(len(buf)) + 16
+	}
+
+	// We're done with the buffer.
+	err = cdb.bufferedWriter.Flush()
+	cdb.bufferedWriter = nil
 	if err != nil {
-		return index, err
+		return err
+	}
+
+	// Dropping dead records, or sorting, can leave stale data past the end.
+	if truncater, ok := cdb.writer.(interface{ Truncate(int64) error }); ok && (cdb.options.SortData || len(cdb.dead) > 0) {
+		err = truncater.Truncate(cdb.bufferedOffset)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Otherwise, seek to the beginning of the file and write out the index.
+	if !cdb.options.HeaderLast {
+		_, err = cdb.writer.Seek(0, os.SEEK_SET)
+		if err != nil {
+			return err
+		}
+
+		if cdb.options.Versioned {
+			_, err = cdb.writer.Write(p.marshal())
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = cdb.writer.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	// For stream writers, copy the finished database out in one pass.
+	if cdb.stream != nil {
+		_, err = cdb.tempFile.Seek(0, os.SEEK_SET)
+		if err != nil {
+			return err
+		}
+
+		if cdb.compressBlockSize > 0 {
+			r := bufio.NewReaderSize(cdb.tempFile, cdb.options.BufferSize)
+			err = writeCompressed(cdb.stream, r, cdb.bufferedOffset, cdb.compressBlockSize)
+		} else {
+			_, err = io.Copy(cdb.stream, cdb.tempFile)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if cdb.options.Sync {
+		var w interface{} = cdb.writer
+		if cdb.stream != nil {
+			w = cdb.stream
+		}
+
+		if syncer, ok := w.(interface{ Sync() error }); ok {
+			err = syncer.Sync()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if cdb.atomicPath != "" {
+		return cdb.commitAtomic()
+	}
+
+	return nil
+}
+
+// preamble returns the preamble for the database, which is known from the
+// options from the start, except for the offset of the trailer.
+func (cdb *Writer) preamble() preamble {
+	p := preamble{version: formatVersion, hash: cdb.hashID}
+	if cdb.options.Checksum {
+		p.flags |= flagChecksum
+	}
+
+	if cdb.options.BloomFalsePositiveRate > 0 {
+		p.flags |= flagBloom
+	}
+
+	if cdb.options.Codec != nil {
+		p.flags |= flagCodec
+		p.codec = cdb.options.Codec.ID()
+	}
+
+	if cdb.options.SortedKeys {
+		p.flags |= flagSortedKeys
+	}
+
+	if cdb.options.RecordChecksums {
+		p.flags |= flagRecordChecksum
+	}
+
+	if cdb.options.Expiry {
+		p.flags |= flagExpiry
+	}
+
+	if cdb.options.HeaderLast {
+		p.flags |= flagHeaderLast
+	}
+
+	if cdb.options.Hash128 {
+		p.flags |= flagHash128
+	}
+
+	if len(cdb.entries) != defaultTables {
+		p.tables = uint32(len(cdb.entries))
+	}
+
+	return p
+}
+
+// buildBloom builds a bloom filter of every entry's hash.
+func (cdb *Writer) buildBloom() (*bloomFilter, error) {
+	bloom := newBloomFilter(cdb.NumRecords(), cdb.options.BloomFalsePositiveRate)
+	for i := range cdb.entries {
+		tableEntries, err := cdb.tableEntries(i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range tableEntries {
+			bloom.add(entry.hash)
+		}
+	}
+
+	return bloom, nil
+}
+
+// slotEntries lays out a hash table for the given entries, with twice as many
+// slots as entries, using linear probing from each entry's hash.
+//
+// Empty slots are told apart by their zero offset, not their hash: a key can
+// legitimately hash to zero, but no record starts at offset zero, where the
+// header is.
+func slotEntries(tableEntries []entry, tableBits uint) []entry {
+	tableSize := uint64(len(tableEntries) << 1)
+	sorted := make([]entry, tableSize)
+	for _, entry := range tableEntries {
+		slot := firstSlot(entry.hash, tableBits, tableSize)
+
+		for {
+			if sorted[slot].offset == 0 {
+				sorted[slot] = entry
+				break
+			}
+
+			slot = (slot + 1) % tableSize
+		}
+	}
+
+	return sorted
+}
+
+// closeStream removes the temporary file backing a stream writer, then closes
+// the stream, if finalizing it succeeded.
+func (cdb *Writer) closeStream(err error) error {
+	f := cdb.tempFile
+	if f == nil {
+		return err
+	}
+
+	cdb.tempFile = nil
+	closeErr := f.Close()
+	removeErr := os.Remove(f.Name())
+	if err != nil {
+		return err
+	} else if closeErr != nil {
+		return closeErr
+	} else if removeErr != nil {
+		return removeErr
+	}
+
+	if closer, ok := cdb.stream.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// slotSize returns the size of a hash table slot, in bytes.
+func (cdb *Writer) slotSize() int64 {
+	if cdb.options.Hash128 {
+		return 24
+	}
+
+	return 16
+}
+
+// dataStart returns the offset of the first record in the file.
+func (cdb *Writer) dataStart() uint64 {
+	if cdb.options.HeaderLast {
+		return preambleSize
+	}
+
+	indexSize := uint64(len(cdb.entries)) * 16
+	if cdb.options.Versioned {
+		return preambleSize + indexSize
 	}
 
-	return index, nil
+	return indexSize
 }
@@ -1,14 +1,21 @@
 package cdb64
 
 import (
+	"bytes"
+	"errors"
+	"hash"
 	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"math/rand"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"testing/quick"
 	"time"
 
@@ -63,6 +70,293 @@ func TestWritesReadableFnv(t *testing.T) {
 	testWritesReadable(t, writer)
 }
 
+func TestWritesReadableSmallBuffer(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{BufferSize: 16})
+	require.NoError(t, err)
+	require.NotNil(t, writer)
+
+	testWritesReadable(t, writer)
+}
+
+func TestPutAll(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+
+	var pairs []KV
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		pairs = append(pairs, KV{Key: record[0], Value: record[1]})
+	}
+
+	require.NoError(t, writer.PutAll(pairs))
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+		value, err := db.Get(record[0])
+		require.NoError(t, err, msg)
+		assert.Equal(t, string(record[1]), string(value), msg)
+	}
+
+	n := 0
+	iter := db.Iter()
+	for iter.Next() {
+		assert.Equal(t, string(pairs[n].Key), string(iter.Key()))
+		n++
+	}
+
+	assert.Equal(t, len(pairs), n)
+}
+
+func TestPutStringAndUint64(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, writer.PutString("name", []byte("value")))
+	require.NoError(t, writer.PutString("", []byte("empty_key")))
+	require.NoError(t, writer.PutUint64([]byte("zero"), 0))
+	require.NoError(t, writer.PutUint64([]byte("big"), 1<<63+12345))
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+
+	value, found, err := db.GetString("name")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "value", value)
+
+	value, found, err = db.GetString("")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "empty_key", value)
+
+	v, found, err := db.GetUint64([]byte("zero"))
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, uint64(0), v)
+
+	v, found, err = db.GetUint64([]byte("big"))
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, uint64(1<<63+12345), v)
+
+	_, found, err = db.GetUint64([]byte("missing"))
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, found, err = db.GetUint64([]byte("name"))
+	assert.Error(t, err)
+	assert.True(t, found)
+}
+
+func TestPutStream(t *testing.T) {
+	for _, opts := range []WriterOptions{{}, {RecordChecksums: true, Expiry: true}, {Checksum: true, MaxMemory: 24}, {Codec: GzipCodec{}}} {
+		f, err := ioutil.TempFile("", "test-cdb")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+
+		writer, err := NewWriterWithOptions(f, opts)
+		require.NoError(t, err)
+
+		large := bytes.Repeat([]byte("abcdefgh"), 100000)
+		require.NoError(t, writer.PutStream([]byte("large"), bytes.NewReader(large), int64(len(large))))
+		require.NoError(t, writer.PutStream([]byte("empty"), bytes.NewReader(nil), 0))
+
+		// A short value is left as dead space, and dropped when finalizing.
+		err = writer.PutStream([]byte("short"), strings.NewReader("abc"), 10)
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+		require.NoError(t, writer.Put([]byte("after"), []byte("value")))
+
+		// So is a value whose reader fails partway through.
+		failed := errors.New("read failed")
+		err = writer.PutStream([]byte("failing"), io.MultiReader(strings.NewReader("abc"), iotest.ErrReader(failed)), 10)
+		assert.Equal(t, failed, err)
+		require.NoError(t, writer.Put([]byte("after failing"), []byte("value")))
+
+		db, err := writer.Freeze()
+		require.NoError(t, err)
+
+		value, err := db.Get([]byte("large"))
+		require.NoError(t, err)
+		assert.Equal(t, large, value)
+
+		value, err = db.Get([]byte("empty"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte{}, value)
+
+		value, err = db.Get([]byte("short"))
+		require.NoError(t, err)
+		assert.Nil(t, value)
+
+		for _, key := range []string{"after", "after failing"} {
+			value, err = db.Get([]byte(key))
+			require.NoError(t, err)
+			assert.Equal(t, "value", string(value))
+		}
+
+		value, err = db.Get([]byte("failing"))
+		require.NoError(t, err)
+		assert.Nil(t, value)
+
+		keys, err := db.Keys()
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("large"), []byte("empty"), []byte("after"), []byte("after failing")}, keys)
+		require.NoError(t, db.Verify())
+		if opts.Checksum {
+			require.NoError(t, db.VerifyChecksum())
+		}
+
+		require.NoError(t, db.Close())
+	}
+
+	// Without a way to read the records back, the dead space can't be
+	// dropped, so the Writer stops.
+	writer, err := NewStreamWriterWithOptions(ioutil.Discard, WriterOptions{HeaderLast: true})
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Put([]byte("before"), []byte("value")))
+	err = writer.PutStream([]byte("short"), strings.NewReader("abc"), 10)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, ErrClosed, writer.Put([]byte("after"), []byte("value")))
+	assert.Equal(t, io.ErrUnexpectedEOF, writer.Close())
+}
+
+func TestDelete(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		require.NoError(t, writer.Put(record[0], record[1]))
+	}
+
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar again")))
+	require.NoError(t, writer.Delete([]byte("foo")))
+	require.NoError(t, writer.Delete([]byte("playwright")))
+	require.NoError(t, writer.Delete([]byte("not in the table")))
+	require.NoError(t, writer.Put([]byte("playwright"), []byte("encore")))
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+	assert.Equal(t, len(expectedRecords)-2, db.Count())
+
+	for _, record := range expectedRecords {
+		msg := "while fetching " + string(record[0])
+		value, err := db.Get(record[0])
+		require.NoError(t, err, msg)
+
+		switch string(record[0]) {
+		case "foo":
+			assert.Nil(t, value, msg)
+		case "playwright":
+			assert.Equal(t, "encore", string(value), msg)
+		default:
+			assert.Equal(t, string(record[1]), string(value), msg)
+		}
+	}
+
+	// The deleted records are gone from the file, not just the hash tables.
+	keys, err := db.Keys()
+	require.NoError(t, err)
+	assert.Len(t, keys, len(expectedRecords)-2)
+	assert.NotContains(t, keys, []byte("foo"))
+	assert.Equal(t, []byte("playwright"), keys[len(keys)-1])
+	require.NoError(t, db.Verify())
+	assert.Equal(t, ErrClosed, writer.Delete([]byte("baz")))
+
+	// Records can't be told apart without reading them back.
+	writer, err = NewStreamWriterWithOptions(ioutil.Discard, WriterOptions{HeaderLast: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	assert.Error(t, writer.Delete([]byte("foo")))
+	require.NoError(t, writer.Close())
+}
+
+func TestRejectDuplicates(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{RejectDuplicates: true})
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Put([]byte("baz"), []byte("qux")))
+	assert.ErrorIs(t, writer.Put([]byte("foo"), []byte("again")), ErrDuplicateKey)
+	assert.ErrorIs(t, writer.PutStream([]byte("baz"), strings.NewReader("again"), 5), ErrDuplicateKey)
+
+	// A deleted key can be written again.
+	require.NoError(t, writer.Delete([]byte("foo")))
+	require.NoError(t, writer.Put([]byte("foo"), []byte("again")))
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+	assert.Equal(t, 2, db.Count())
+
+	value, err := db.Get([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "again", string(value))
+}
+
+func TestRejectDuplicatesCollisions(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	// Keys that collide are told apart by reading them back.
+	writer, err := NewWriterWithOptions(f, WriterOptions{Hasher: newConstantHash, RejectDuplicates: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Put([]byte("baz"), []byte("qux")))
+	assert.ErrorIs(t, writer.Put([]byte("baz"), []byte("again")), ErrDuplicateKey)
+
+	// That isn't possible if the writer can't be read from.
+	writer, err = NewWriterWithOptions(struct{ io.WriteSeeker }{f}, WriterOptions{Hasher: newConstantHash, RejectDuplicates: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	assert.ErrorIs(t, writer.Put([]byte("baz"), []byte("qux")), ErrDuplicateKey)
+}
+
+func TestOnFinalizeProgress(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	var progress []int
+	writer, err := NewWriterWithOptions(f, WriterOptions{
+		OnFinalizeProgress: func(tablesDone, totalTables int) {
+			assert.Equal(t, 256, totalTables)
+			progress = append(progress, tablesDone)
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	assert.Empty(t, progress)
+	require.NoError(t, writer.Close())
+
+	require.Len(t, progress, 256)
+	for i, done := range progress {
+		assert.Equal(t, i+1, done)
+	}
+}
+
 func testWritesRandom(t *testing.T, writer *Writer) {
 	random := rand.New(rand.NewSource(time.Now().UnixNano()))
 	records := make([][][]byte, 0, 1000)
@@ -122,6 +416,89 @@ func TestWritesRandomFnv(t *testing.T) {
 	testWritesRandom(t, writer)
 }
 
+func TestStreamWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-cdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A pipe can't be seeked, so the database has to be written in order.
+	r, w := io.Pipe()
+	result := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		result <- data
+	}()
+
+	writer, err := NewStreamWriterWithOptions(w, WriterOptions{TempDir: dir})
+	require.NoError(t, err)
+
+	for _, record := range expectedRecords[:len(expectedRecords)-1] {
+		require.NoError(t, writer.Put(record[0], record[1]))
+	}
+
+	require.NoError(t, writer.Close())
+	data := <-result
+
+	// The temporary file should be gone.
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	db, err := New(bytes.NewReader(data), nil)
+	require.NoError(t, err)
+	for _, record := range expectedRecords {
+		value, err := db.Get(record[0])
+		require.NoError(t, err)
+		assert.Equal(t, string(record[1]), string(value))
+	}
+}
+
+func TestTooMuchData(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+
+	// Pretend the file is already nearly 8EB long.
+	writer.bufferedOffset = math.MaxInt64 - 40
+	assert.Equal(t, ErrTooMuchData, writer.Put([]byte("foo"), []byte("bar")))
+}
+
+// TestWritesPast4GB writes a database bigger than 4GB, to make sure no offset
+// is truncated to 32 bits anywhere. It needs that much free disk space, so it
+// only runs if CDB64_TEST_LARGE is set.
+func TestWritesPast4GB(t *testing.T) {
+	if os.Getenv("CDB64_TEST_LARGE") == "" {
+		t.Skip("set CDB64_TEST_LARGE to write a 4GB+ database")
+	}
+
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+
+	chunk := make([]byte, 64<<20)
+	for i := 0; i < 65; i++ {
+		require.NoError(t, writer.Put([]byte("chunk"+strconv.Itoa(i)), chunk))
+	}
+
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.True(t, db.header[0].offset > 1<<32)
+	value, err := db.Get([]byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+}
+
 func benchmarkPut(b *testing.B, writer *Writer) {
 	random := rand.New(rand.NewSource(time.Now().UnixNano()))
 	stringType := reflect.TypeOf("")
@@ -137,33 +514,59 @@ func benchmarkPut(b *testing.B, writer *Writer) {
 }
 
 func BenchmarkPut(b *testing.B) {
-	f, err := ioutil.TempFile("", "test-cdb")
-	require.NoError(b, err)
-	defer func() {
-		f.Close()
-		os.Remove(f.Name())
-	}()
-
-	writer, err := NewWriter(f, nil)
+	writer, err := NewWriter(&Buffer{}, nil)
 	require.NoError(b, err)
 
 	benchmarkPut(b, writer)
 }
 
 func BenchmarkPutFnv(b *testing.B) {
-	f, err := ioutil.TempFile("", "test-cdb")
-	require.NoError(b, err)
-	defer func() {
-		f.Close()
-		os.Remove(f.Name())
-	}()
-
-	writer, err := NewWriter(f, fnv.New64a)
+	writer, err := NewWriter(&Buffer{}, fnv.New64a)
 	require.NoError(b, err)
 
 	benchmarkPut(b, writer)
 }
 
+func BenchmarkPutMillionSmallRecords(b *testing.B) {
+	keys := make([][]byte, 1000000)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		writer, err := NewWriter(&Buffer{}, fnv.New64a)
+		require.NoError(b, err)
+
+		for _, key := range keys {
+			writer.Put(key, key)
+		}
+
+		require.NoError(b, writer.Close())
+	}
+}
+
+func BenchmarkFinalize(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		f, err := ioutil.TempFile("", "test-cdb")
+		require.NoError(b, err)
+
+		writer, err := NewWriter(f, NewXXHash)
+		require.NoError(b, err)
+
+		for j := 0; j < 4000000; j++ {
+			key := []byte(strconv.Itoa(j))
+			require.NoError(b, writer.Put(key, key))
+		}
+
+		b.StartTimer()
+		require.NoError(b, writer.Close())
+		os.Remove(f.Name())
+	}
+}
+
 func ExampleWriter() {
 	writer, err := Create("/tmp/example.cdb")
 	if err != nil {
@@ -179,3 +582,380 @@ func ExampleWriter() {
 	// records.
 	writer.Close()
 }
+
+// syncFile records calls to Sync.
+type syncFile struct {
+	*os.File
+	syncs int
+}
+
+func (f *syncFile) Sync() error {
+	f.syncs++
+	return f.File.Sync()
+}
+
+func TestSync(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	synced := &syncFile{File: f}
+	writer, err := NewWriterWithOptions(synced, WriterOptions{Sync: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	assert.Equal(t, 0, synced.syncs)
+
+	require.NoError(t, writer.Close())
+	assert.Equal(t, 1, synced.syncs)
+
+	// Without the option, nothing is synced.
+	f, err = ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	synced = &syncFile{File: f}
+	writer, err = NewWriter(synced, nil)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	assert.Equal(t, 0, synced.syncs)
+}
+
+func TestPreallocate(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{PreallocateSize: 1 << 20})
+	require.NoError(t, err)
+
+	testWritesReadable(t, writer)
+
+	// Preallocating doesn't leave any padding at the end of the file.
+	info, err := f.Stat()
+	require.NoError(t, err)
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, db.Size(), info.Size())
+
+	// Stream writers preallocate their temporary file instead.
+	writer, err = NewStreamWriterWithOptions(ioutil.Discard, WriterOptions{PreallocateSize: 1 << 20})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+}
+
+// zeroHash is the cdb hash, except that the key "zero" hashes to exactly 0,
+// which the default hash makes too hard to find a key for.
+type zeroHash struct {
+	hash.Hash64
+	key []byte
+}
+
+func newZeroHash() hash.Hash64 {
+	return &zeroHash{Hash64: newCDBHash()}
+}
+
+func (h *zeroHash) Write(data []byte) (int, error) {
+	h.key = append(h.key, data...)
+	return h.Hash64.Write(data)
+}
+
+func (h *zeroHash) Sum64() uint64 {
+	if string(h.key) == "zero" {
+		return 0
+	}
+
+	return h.Hash64.Sum64()
+}
+
+func (h *zeroHash) Reset() {
+	h.key = h.key[:0]
+	h.Hash64.Reset()
+}
+
+func TestWritesZeroHash(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, newZeroHash)
+	require.NoError(t, err)
+
+	// Both records land in table 0; the second must not overwrite the
+	// first's slot, even though its hash is the same as an empty slot's.
+	require.NoError(t, writer.Put([]byte("zero"), []byte("first")))
+	require.NoError(t, writer.Put([]byte("zero"), []byte("second")))
+	require.NoError(t, writer.Close())
+
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, uint64(4), db.header[0].length)
+	occupied := 0
+	for slot := uint64(0); slot < db.header[0].length; slot++ {
+		hash, offset, err := readTuple(db.reader, db.header[0].offset+16*slot)
+		require.NoError(t, err)
+		if offset != 0 {
+			assert.Equal(t, uint64(0), hash)
+			occupied++
+		}
+	}
+
+	assert.Equal(t, 2, occupied)
+}
+
+func TestReset(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(f, WriterOptions{Checksum: true})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("first")))
+	}
+	require.NoError(t, writer.Close())
+
+	for round := 0; round < 3; round++ {
+		f2, err := ioutil.TempFile("", "test-cdb")
+		require.NoError(t, err)
+		defer os.Remove(f2.Name())
+
+		require.NoError(t, writer.Reset(f2))
+		for i := 50; i < 60; i++ {
+			require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("next")))
+		}
+
+		db, err := writer.Freeze()
+		require.NoError(t, err)
+		require.NoError(t, db.Verify())
+		require.NoError(t, db.VerifyChecksum())
+		assert.Equal(t, 10, db.Count())
+
+		value, err := db.Get([]byte("55"))
+		require.NoError(t, err)
+		assert.Equal(t, "next", string(value))
+
+		value, err = db.Get([]byte("5"))
+		require.NoError(t, err)
+		assert.Nil(t, value)
+		require.NoError(t, db.Close())
+	}
+
+	// The first database is untouched.
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, 100, db.Count())
+	require.NoError(t, db.VerifyChecksum())
+}
+
+func TestTableCount(t *testing.T) {
+	for _, tables := range []int{1, 16, 256, 4096} {
+		f, err := ioutil.TempFile("", "test-cdb")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+
+		writer, err := NewWriterWithOptions(f, WriterOptions{TableCount: tables})
+		require.NoError(t, err)
+
+		testWritesReadable(t, writer)
+
+		db, err := Open(f.Name())
+		require.NoError(t, err)
+		defer db.Close()
+
+		require.NoError(t, db.Verify())
+		stats := db.Stats()
+		assert.Len(t, stats.Slots, tables)
+		assert.Equal(t, 100, stats.Records)
+
+		// Only the default count can be written without a preamble.
+		assert.Equal(t, tables != 256, db.dataStart != headerSize)
+
+		writer, err = OpenForAppend(f.Name())
+		require.NoError(t, err)
+		require.NoError(t, writer.Put([]byte("appended"), []byte("value")))
+		db, err = writer.Freeze()
+		require.NoError(t, err)
+		defer db.Close()
+
+		require.NoError(t, db.Verify())
+		assert.Len(t, db.header, tables)
+		value, err := db.Get([]byte("appended"))
+		require.NoError(t, err)
+		assert.Equal(t, "value", string(value))
+	}
+
+	for _, tables := range []int{-1, 3, 1 << 21} {
+		_, err := NewWriterWithOptions(nil, WriterOptions{TableCount: tables})
+		assert.Error(t, err, "table count %d", tables)
+	}
+}
+
+func TestSuggestTableCount(t *testing.T) {
+	for records, expected := range map[int]int{
+		0:         1,
+		128:       1,
+		129:       2,
+		32768:     256,
+		32769:     512,
+		1 << 30:   maxTables,
+		1000000:   8192,
+		100000000: 1 << 20,
+	} {
+		tables := SuggestTableCount(records)
+		assert.Equal(t, expected, tables, "%d records", records)
+		_, err := tableBits(tables)
+		assert.NoError(t, err)
+	}
+
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	writer, err := CreateWithOptions(f.Name(), WriterOptions{ExpectedRecords: 1000})
+	require.NoError(t, err)
+
+	testWritesReadable(t, writer)
+
+	db, err := Open(f.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Verify())
+	assert.Len(t, db.header, 8)
+}
+
+func TestEstimatedSize(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(headerSize), writer.EstimatedSize())
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("value")))
+	}
+
+	estimate := writer.EstimatedSize()
+	require.NoError(t, writer.Close())
+
+	info, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), estimate)
+}
+
+func TestNumRecords(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, writer.NumRecords())
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, writer.Put([]byte(strconv.Itoa(i)), []byte("value")))
+	}
+
+	require.NoError(t, writer.Put([]byte("0"), []byte("again")))
+	require.NoError(t, writer.Delete([]byte("1")))
+	assert.Equal(t, 1000, writer.NumRecords())
+
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, 1000, writer.NumRecords())
+	assert.Equal(t, 1000, db.Stats().Records)
+}
+
+func TestPutAfterClose(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriter(f, nil)
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.Close())
+
+	assert.Equal(t, ErrClosed, writer.Put([]byte("baz"), []byte("quux")))
+	assert.Equal(t, ErrClosed, writer.PutStream([]byte("baz"), strings.NewReader("quux"), 4))
+
+	// Closing again doesn't close the file twice.
+	require.NoError(t, writer.Close())
+
+	writer, err = NewWriter(&Buffer{}, nil)
+	require.NoError(t, err)
+	db, err := writer.Freeze()
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrClosed, writer.Put([]byte("baz"), []byte("quux")))
+	require.NoError(t, writer.Close())
+	require.NoError(t, db.Close())
+}
+
+// failingSync is a file whose Sync always fails.
+type failingSync struct {
+	*os.File
+}
+
+func (f failingSync) Sync() error {
+	return errors.New("sync failed")
+}
+
+func TestCloseAfterFailure(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-cdb")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	writer, err := NewWriterWithOptions(failingSync{f}, WriterOptions{Sync: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+
+	// The failure is reported every time, and the file is closed anyway.
+	assert.EqualError(t, writer.Close(), "sync failed")
+	assert.EqualError(t, writer.Close(), "sync failed")
+	_, err = writer.Freeze()
+	assert.EqualError(t, err, "sync failed")
+	assert.Error(t, f.Close())
+
+	assert.Equal(t, ErrClosed, writer.Delete([]byte("foo")))
+}
+
+func TestNewWriterNil(t *testing.T) {
+	_, err := NewWriter(nil, nil)
+	assert.Equal(t, ErrNilWriter, err)
+
+	_, err = NewStreamWriter(nil, nil)
+	assert.Equal(t, ErrNilWriter, err)
+}
+
+func TestOnPut(t *testing.T) {
+	var seen []string
+	var buf Buffer
+	writer, err := NewWriterWithOptions(&buf, WriterOptions{
+		Codec:            GzipCodec{},
+		RejectDuplicates: true,
+		OnPut: func(key, value []byte) {
+			seen = append(seen, string(key)+"="+string(value))
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, writer.PutString("baz", []byte("quux")))
+
+	// A failed write isn't reported.
+	assert.Error(t, writer.Put([]byte("foo"), []byte("again")))
+	require.NoError(t, writer.Close())
+
+	assert.Equal(t, []string{"foo=bar", "baz=quux"}, seen)
+}